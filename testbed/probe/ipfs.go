@@ -328,6 +328,15 @@ func getContent(ctx context.Context, n *IPFSNode, fPath path.Path, pin bool) err
 		s, _ := f.Size()
 		fmt.Printf("[*] Size of the file obtained %d in %s\n", s, timeToFetch)
 		fmt.Println("Wrote in ")
+		if recorder != nil {
+			resolved, err := n.API.ResolvePath(ctx, fPath)
+			if err != nil {
+				return err
+			}
+			if err := recorder.fetched(resolved.Cid(), s, start); err != nil {
+				return err
+			}
+		}
 	}
 
 	fmt.Println("Cleaning datastore")
@@ -367,6 +376,9 @@ func addFile(ctx context.Context, n *IPFSNode, inputPathFile string) error {
 	}
 	fmt.Println("Adding file to the network:", cidFile)
 	fmt.Printf("Added in %d (ms)\n", end)
+	if recorder != nil {
+		recorder.added(cidFile.Cid(), inputPathFile)
+	}
 	return nil
 }
 
@@ -99,6 +99,7 @@ func processInput(ctx context.Context, ipfs *IPFSNode, text string, done chan bo
 func main() {
 	addDirectory := flag.String("addDirectory", "", "Add a directory to the probe")
 	debug := flag.Bool("debug", false, "Set debug logging")
+	record := flag.String("record", "", "Record the content got to a workload trace at this path")
 
 	flag.Parse()
 	if *debug {
@@ -106,6 +107,10 @@ func main() {
 		logging.SetLogLevel("bitswap_network", "DEBUG")
 	}
 
+	if *record != "" {
+		recorder = newWorkloadRecorder(*record)
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
 	fmt.Println("-- Getting an IPFS node running -- ")
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// The probe records the content it gets with -record as a workload trace, in
+// the format of the testbed's workload package, so the replay test case of
+// the testbed can replay it. The trace has a single seed and leech, and no
+// traffic shaping. Only files added with addFile_ can be generated again by
+// the replay; other items are recorded without a path, to be filled in with
+// the path of a copy of their content before replaying.
+type workloadTrace struct {
+	Topology workloadTopology  `json:"topology"`
+	Items    []workloadItem    `json:"items"`
+	Requests []workloadRequest `json:"requests"`
+}
+
+type workloadTopology struct {
+	Seeds       int   `json:"seeds"`
+	Leeches     int   `json:"leeches"`
+	Passives    int   `json:"passives"`
+	LatencyMS   int64 `json:"latency_ms"`
+	BandwidthMB int   `json:"bandwidth_mb"`
+	JitterPct   int   `json:"jitter_pct"`
+}
+
+type workloadItem struct {
+	CID  string `json:"cid"`
+	Size int64  `json:"size"`
+	Path string `json:"path,omitempty"`
+}
+
+type workloadRequest struct {
+	Leech    int     `json:"leech"`
+	Item     int     `json:"item"`
+	OffsetMS float64 `json:"offset_ms"`
+}
+
+type workloadRecorder struct {
+	path  string
+	start time.Time
+	// Paths of the files added with addFile_, by CID.
+	paths map[cid.Cid]string
+	trace workloadTrace
+}
+
+// recorder records the gets of the probe if -record is set.
+var recorder *workloadRecorder
+
+func newWorkloadRecorder(path string) *workloadRecorder {
+	return &workloadRecorder{
+		path:  path,
+		start: time.Now(),
+		paths: make(map[cid.Cid]string),
+		trace: workloadTrace{Topology: workloadTopology{Seeds: 1, Leeches: 1}},
+	}
+}
+
+// added remembers the file a CID was added from.
+func (r *workloadRecorder) added(c cid.Cid, path string) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	r.paths[c] = path
+}
+
+// fetched records a get of c started at start, and writes the trace so far,
+// as the probe may exit at any time.
+func (r *workloadRecorder) fetched(c cid.Cid, size int64, start time.Time) error {
+	item := -1
+	for i, it := range r.trace.Items {
+		if it.CID == c.String() {
+			item = i
+		}
+	}
+	if item < 0 {
+		r.trace.Items = append(r.trace.Items, workloadItem{c.String(), size, r.paths[c]})
+		item = len(r.trace.Items) - 1
+	}
+	offset := start.Sub(r.start)
+	r.trace.Requests = append(r.trace.Requests, workloadRequest{0, item, float64(offset) / float64(time.Millisecond)})
+
+	data, err := json.MarshalIndent(r.trace, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(r.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing workload: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Command compare compares the results of a candidate experiment, such as an
+// RFC composition, against a baseline. Results are grouped by the given
+// dimensions, and for every metric it prints the summary of both sides and
+// whether the difference of their means is significant (Welch's t-test).
+//
+// Usage:
+//
+//	compare [flags] <baseline results dir> <candidate results dir>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+func main() {
+	metrics := flag.String("metrics", "time_to_fetch,dup_blks_rcvd,data_sent", "comma-separated metrics to compare")
+	group := flag.String("group", "nodeType,transport,topology,latencyMS,bandwidthMB,fileSize", "comma-separated dimensions to group results by")
+	nodeType := flag.String("node-type", "", "only compare metrics of this node type (Seed, Leech, Passive)")
+	confidence := flag.Float64("confidence", 0.95, "confidence level of the intervals and the significance test")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <baseline results dir> <candidate results dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), flag.Arg(1), split(*metrics), split(*group), *nodeType, *confidence); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(baselineDir, candidateDir string, metrics, group []string, nodeType string, confidence float64) error {
+	if confidence <= 0 || confidence >= 1 {
+		return fmt.Errorf("Confidence must be between 0 and 1")
+	}
+	baseline, err := load(baselineDir, metrics, nodeType)
+	if err != nil {
+		return err
+	}
+	candidate, err := load(candidateDir, metrics, nodeType)
+	if err != nil {
+		return err
+	}
+
+	comparisons := compare(results.GroupBy(baseline, group), results.GroupBy(candidate, group), confidence)
+	if len(comparisons) == 0 {
+		return fmt.Errorf("No results in common between %s and %s", baselineDir, candidateDir)
+	}
+	printTable(os.Stdout, comparisons, confidence)
+	return nil
+}
+
+// load reads the results under dir, keeping only the given metrics.
+func load(dir string, metrics []string, nodeType string) ([]results.Result, error) {
+	res, skipped, err := results.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d lines under %s that aren't metrics of this test plan\n", skipped, dir)
+	}
+	if nodeType != "" {
+		res = results.Filter(res, map[string]string{"nodeType": nodeType})
+	}
+	wanted := make(map[string]bool)
+	for _, m := range metrics {
+		wanted[m] = true
+	}
+	var out []results.Result
+	for _, r := range res {
+		if wanted[r.Name] {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+type comparison struct {
+	group  string
+	metric string
+	results.Comparison
+}
+
+// compare pairs the series of both sides by group and metric.
+func compare(baseline, candidate []results.Series, confidence float64) []comparison {
+	candidates := make(map[[2]string][]float64)
+	for _, s := range candidate {
+		candidates[[2]string{s.Group, s.Metric}] = s.Values
+	}
+	var out []comparison
+	for _, s := range baseline {
+		values, ok := candidates[[2]string{s.Group, s.Metric}]
+		if !ok {
+			continue
+		}
+		out = append(out, comparison{s.Group, s.Metric, results.Compare(s.Values, values, confidence)})
+	}
+	return out
+}
+
+func printTable(f *os.File, comparisons []comparison, confidence float64) {
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	ci := fmt.Sprintf("%.0f%% CI", confidence*100)
+	fmt.Fprintf(w, "group\tmetric\tside\tn\tmean\t%s\tmedian\tp90\tp99\tdiff\tp-value\tsignificant\t\n", ci)
+	for _, c := range comparisons {
+		fmt.Fprintf(w, "%s\t%s\tbaseline\t%s\t\t\t\n", c.group, c.metric, summary(c.Baseline))
+		significant := "no"
+		if c.Significant(1 - confidence) {
+			significant = "yes"
+		}
+		fmt.Fprintf(w, "\t\tcandidate\t%s\t%s\t%s\t%s\t\n", summary(c.Candidate), percent(c.RelDiff), number(c.PValue), significant)
+	}
+	w.Flush()
+}
+
+func summary(s results.Summary) string {
+	return strings.Join([]string{
+		fmt.Sprint(s.N),
+		number(s.Mean),
+		fmt.Sprintf("[%s, %s]", number(s.CILow), number(s.CIHigh)),
+		number(s.Median),
+		number(s.P90),
+		number(s.P99),
+	}, "\t")
+}
+
+func number(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%.4g", v)
+}
+
+func percent(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", v*100)
+}
+
+func split(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
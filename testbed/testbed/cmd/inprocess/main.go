@@ -0,0 +1,79 @@
+// Command inprocess runs the transfer, trade or replay test case with all of its
+// instances in this process, connected over a libp2p mocknet, without
+// testground, a sync service or sidecars. Outputs are laid out like the ones
+// testground collects, so the other commands can read them.
+//
+// Usage:
+//
+//	inprocess -composition <composition.toml> [flags]
+//	inprocess -case transfer -instances 3 [-param key=value ...] [flags]
+//
+// Only bitswap and graphsync nodes can run in process. With -simulate,
+// bitswap transfers run on virtual time over simulated links.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/test"
+)
+
+// params collects repeated -param key=value flags.
+type params map[string]string
+
+func (p params) String() string {
+	var kvs []string
+	for k, v := range p {
+		kvs = append(kvs, k+"="+v)
+	}
+	return strings.Join(kvs, ",")
+}
+
+func (p params) Set(kv string) error {
+	i := strings.Index(kv, "=")
+	if i < 1 {
+		return fmt.Errorf("Parameters must be key=value, got %s", kv)
+	}
+	p[kv[:i]] = kv[i+1:]
+	return nil
+}
+
+func main() {
+	extra := make(params)
+	composition := flag.String("composition", "", "composition to run, with a single group")
+	manifest := flag.String("manifest", "manifest.toml", "manifest with the defaults of the test parameters")
+	testCase := flag.String("case", "transfer", "test case to run without a composition (transfer, trade or replay)")
+	instances := flag.Int("instances", 2, "number of instances to run without a composition")
+	outputs := flag.String("outputs", "outputs", "directory to write the outputs of the instances to")
+	flag.Var(extra, "param", "test parameter as key=value, on top of the composition (repeatable)")
+	simulate := flag.Bool("simulate", false, "run on virtual time over simulated links (bitswap only)")
+	settle := flag.Duration("settle", 10*time.Millisecond, "wall time with nothing going on before virtual time moves on")
+	flag.Parse()
+
+	cfg := test.InProcessConfig{TestCase: *testCase, Instances: *instances, Params: make(map[string]string)}
+	if *composition != "" {
+		var err error
+		if cfg, err = test.ReadComposition(*composition); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	for k, v := range extra {
+		cfg.Params[k] = v
+	}
+	cfg.Manifest = *manifest
+	cfg.OutputsPath = *outputs
+	cfg.Simulate = *simulate
+	cfg.Settle = *settle
+
+	if err := test.RunInProcess(context.Background(), cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Outputs written to %s\n", *outputs)
+}
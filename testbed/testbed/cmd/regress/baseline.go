@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+// threshold is how much a metric of a node type may degrade before the gate
+// fails, in percent of its baseline mean.
+type threshold struct {
+	NodeType         string  `json:"node_type"`
+	Metric           string  `json:"metric"`
+	MaxRegressionPct float64 `json:"max_regression_pct"`
+	// Metrics such as throughput degrade when they go down.
+	HigherIsBetter bool `json:"higher_is_better"`
+}
+
+type thresholdsFile struct {
+	Thresholds []threshold `json:"thresholds"`
+}
+
+func readThresholds(path string) ([]threshold, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f thresholdsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("Error reading thresholds %s: %w", path, err)
+	}
+	if len(f.Thresholds) == 0 {
+		return nil, fmt.Errorf("No thresholds in %s", path)
+	}
+	return f.Thresholds, nil
+}
+
+// series are the values of a gated metric for a node type in a group of
+// results.
+type series struct {
+	NodeType string    `json:"node_type"`
+	Metric   string    `json:"metric"`
+	Group    string    `json:"group"`
+	Values   []float64 `json:"values"`
+}
+
+func (s series) key() [3]string {
+	return [3]string{s.NodeType, s.Metric, s.Group}
+}
+
+// baseline holds the results of the benchmark that later runs are compared
+// against.
+type baseline struct {
+	Composition string    `json:"composition"`
+	Created     time.Time `json:"created"`
+	Series      []series  `json:"series"`
+}
+
+func readBaseline(path string) (*baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No baseline in %s, store one from the current version first with -update, using the same runner", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("Error reading baseline %s: %w", path, err)
+	}
+	return &b, nil
+}
+
+func (b *baseline) write(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// collect groups the values of every gated metric.
+func collect(res []results.Result, thresholds []threshold, group []string) []series {
+	var out []series
+	for _, t := range thresholds {
+		matching := results.Filter(res, map[string]string{"nodeType": t.NodeType})
+		for _, s := range results.GroupBy(matching, group) {
+			if s.Metric == t.Metric {
+				out = append(out, series{t.NodeType, t.Metric, s.Group, s.Values})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,239 @@
+// Command regress is a performance regression gate for changes to the
+// exchange, such as a new version of one of the forks go.mod points to. It
+// runs a fixed benchmark composition and compares its results with a stored
+// baseline, exiting with status 1 if a gated metric degrades beyond its
+// threshold.
+//
+// Usage:
+//
+//	regress [flags]          run the benchmark and compare it with the baseline
+//	regress -update [flags]  run the benchmark and store it as the baseline
+//
+// The benchmark runs in this process unless -runner=testground. Results of
+// the two runners aren't comparable, so each has a baseline of its own.
+//
+// Thresholds are set per node type and metric in a JSON file, see
+// compositions/regression-thresholds.json.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+type options struct {
+	composition string
+	baseline    string
+	thresholds  string
+	results     string
+	update      bool
+	group       []string
+	alpha       float64
+	runner      runner
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.composition, "composition", "../compositions/regression.toml", "benchmark composition")
+	flag.StringVar(&opts.baseline, "baseline", "", "baseline file (default ../compositions/regression-baseline.json, or regression-baseline-testground.json with -runner=testground)")
+	flag.StringVar(&opts.thresholds, "thresholds", "../compositions/regression-thresholds.json", "thresholds file")
+	flag.StringVar(&opts.results, "results", "", "use the outputs in this directory instead of running the benchmark")
+	flag.BoolVar(&opts.update, "update", false, "store the results as the new baseline")
+	group := flag.String("group", "latencyMS,bandwidthMB,fileSize", "comma-separated dimensions to group results by")
+	flag.Float64Var(&opts.alpha, "alpha", 0.05, "significance level a degradation must reach to fail the gate (1 to gate on the means alone)")
+	runnerName := flag.String("runner", "in-process", "how to run the benchmark: in-process or testground")
+	manifest := flag.String("manifest", "manifest.toml", "manifest of the plan, for the in-process runner")
+	testground := flag.String("testground", "testground", "testground binary, for the testground runner")
+	flag.Parse()
+	opts.group = strings.Split(*group, ",")
+	switch *runnerName {
+	case "in-process":
+		opts.runner = inProcessRunner{*manifest}
+		if opts.baseline == "" {
+			opts.baseline = "../compositions/regression-baseline.json"
+		}
+	case "testground":
+		opts.runner = testgroundRunner{*testground}
+		if opts.baseline == "" {
+			opts.baseline = "../compositions/regression-baseline-testground.json"
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown runner %s\n", *runnerName)
+		os.Exit(2)
+	}
+
+	failed, err := run(context.Background(), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// run returns whether the gate failed.
+func run(ctx context.Context, opts options) (bool, error) {
+	thresholds, err := readThresholds(opts.thresholds)
+	if err != nil {
+		return false, err
+	}
+
+	dir := opts.results
+	if dir == "" {
+		if dir, err = ioutil.TempDir("", "regress"); err != nil {
+			return false, err
+		}
+		defer os.RemoveAll(dir)
+		if err := opts.runner.run(ctx, opts.composition, dir); err != nil {
+			return false, err
+		}
+	}
+	res, skipped, err := results.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d lines under %s that aren't metrics of this test plan\n", skipped, dir)
+	}
+	current := collect(res, thresholds, opts.group)
+	if len(current) == 0 {
+		return false, fmt.Errorf("No gated metrics in the results under %s", dir)
+	}
+
+	if opts.update {
+		b := &baseline{opts.composition, time.Now().UTC(), current}
+		if err := b.write(opts.baseline); err != nil {
+			return false, err
+		}
+		fmt.Printf("Stored %d series as the baseline in %s\n", len(current), opts.baseline)
+		return false, nil
+	}
+
+	b, err := readBaseline(opts.baseline)
+	if err != nil {
+		return false, err
+	}
+	checks := check(b.Series, current, thresholds, opts.alpha)
+	printChecks(os.Stdout, checks)
+	for _, c := range checks {
+		if c.status == regressed || c.status == missing {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type status string
+
+const (
+	passed    status = "ok"
+	regressed status = "REGRESSED"
+	missing   status = "MISSING"
+)
+
+type checkResult struct {
+	series    series
+	threshold threshold
+	results.Comparison
+	// Degradation of the mean, in percent of the baseline.
+	degradation float64
+	status      status
+}
+
+// check compares every baseline series with the current one. Series missing
+// from the current results fail the gate, since the metric is no longer
+// reported at all.
+func check(base, current []series, thresholds []threshold, alpha float64) []checkResult {
+	limits := make(map[[2]string]threshold)
+	for _, t := range thresholds {
+		limits[[2]string{t.NodeType, t.Metric}] = t
+	}
+	byKey := make(map[[3]string]series)
+	for _, s := range current {
+		byKey[s.key()] = s
+	}
+
+	var checks []checkResult
+	for _, b := range base {
+		t, ok := limits[[2]string{b.NodeType, b.Metric}]
+		if !ok {
+			// The metric is no longer gated.
+			continue
+		}
+		c := checkResult{series: b, threshold: t, degradation: math.NaN(), status: missing}
+		cur, ok := byKey[b.key()]
+		if ok {
+			c.Comparison = results.Compare(b.Values, cur.Values, 1-alpha)
+			c.degradation = degradation(c.Baseline.Mean, c.Candidate.Mean, t.HigherIsBetter)
+			c.status = passed
+			significant := alpha >= 1 || c.Significant(alpha)
+			if c.degradation > t.MaxRegressionPct && significant {
+				c.status = regressed
+			}
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// degradation returns how much worse the current mean is than the baseline,
+// in percent. Any degradation from a zero baseline is infinite.
+func degradation(base, current float64, higherIsBetter bool) float64 {
+	diff := current - base
+	if higherIsBetter {
+		diff = -diff
+	}
+	if base == 0 {
+		switch {
+		case diff > 0:
+			return math.Inf(1)
+		case diff < 0:
+			return math.Inf(-1)
+		}
+		return 0
+	}
+	return diff / math.Abs(base) * 100
+}
+
+func printChecks(f *os.File, checks []checkResult) {
+	w := tabwriter.NewWriter(f, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "node type\tmetric\tgroup\tbaseline\tcurrent\tdegradation\tthreshold\tp-value\tstatus\t")
+	for _, c := range checks {
+		current, p := "-", "-"
+		if c.status != missing {
+			current = number(c.Candidate.Mean)
+			p = number(c.PValue)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.1f%%\t%s\t%s\t\n", c.series.NodeType, c.series.Metric, c.series.Group,
+			number(mean(c.series.Values)), current, percent(c.degradation), c.threshold.MaxRegressionPct, p, c.status)
+	}
+	w.Flush()
+}
+
+func mean(values []float64) float64 {
+	return results.Summarize(values, 0.95).Mean
+}
+
+func number(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%.4g", v)
+}
+
+func percent(v float64) string {
+	if math.IsNaN(v) {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", v)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/test"
+)
+
+// runner runs the benchmark composition and leaves the outputs of its
+// instances under dir.
+type runner interface {
+	run(ctx context.Context, composition string, dir string) error
+}
+
+// testgroundRunner runs the composition with the testground daemon, with the
+// builder and runner set in the composition (local:exec for the benchmark).
+type testgroundRunner struct {
+	bin string
+}
+
+func (r testgroundRunner) run(ctx context.Context, composition string, dir string) error {
+	archive := filepath.Join(dir, "outputs.tgz")
+	cmd := exec.CommandContext(ctx, r.bin, "run", "composition", "-f", composition,
+		"--wait", "--collect", "--collect-file", archive)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error running %s: %w", composition, err)
+	}
+	return extract(archive, dir)
+}
+
+// inProcessRunner runs the composition in this process over a mocknet, see
+// test.RunInProcess. It's faster and needs no daemon, but its baseline isn't
+// comparable with one stored by testgroundRunner.
+type inProcessRunner struct {
+	manifest string
+}
+
+func (r inProcessRunner) run(ctx context.Context, composition string, dir string) error {
+	cfg, err := test.ReadComposition(composition)
+	if err != nil {
+		return err
+	}
+	cfg.Manifest = r.manifest
+	cfg.OutputsPath = dir
+	if err := test.RunInProcess(ctx, cfg); err != nil {
+		return fmt.Errorf("Error running %s in process: %w", composition, err)
+	}
+	return nil
+}
+
+// extract unpacks a gzipped tarball of outputs into dir.
+func extract(archive string, dir string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %w", archive, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", archive, err)
+		}
+		path := filepath.Join(dir, hdr.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("Invalid path %s in %s", hdr.Name, archive)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			out, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
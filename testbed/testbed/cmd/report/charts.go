@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// The charts are drawn as inline SVG so the report has no dependencies.
+
+const (
+	chartWidth   = 520
+	chartHeight  = 320
+	marginLeft   = 70
+	marginRight  = 20
+	marginTop    = 30
+	marginBottom = 50
+	legendRow    = 16
+	// Bar labels are rotated by labelAngle degrees, and are about
+	// labelCharWidth pixels wide per character.
+	labelAngle     = 35
+	labelCharWidth = 5.5
+)
+
+var palette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// point is a mean with its confidence interval.
+type point struct {
+	X, Y      float64
+	Low, High float64
+}
+
+type lineSeries struct {
+	Name   string
+	Points []point
+}
+
+type lineChart struct {
+	Title  string
+	XLabel string
+	YLabel string
+	Series []lineSeries
+}
+
+// axis maps values of a dimension to pixels.
+type axis struct {
+	min, max float64
+	from, to float64
+	ticks    []float64
+}
+
+func newAxis(min, max, from, to float64) axis {
+	if min > 0 {
+		min = 0
+	}
+	if max <= min {
+		max = min + 1
+	}
+	step := niceStep((max - min) / 5)
+	a := axis{min: math.Floor(min/step) * step, max: math.Ceil(max/step) * step, from: from, to: to}
+	for v := a.min; v <= a.max+step/2; v += step {
+		a.ticks = append(a.ticks, v)
+	}
+	return a
+}
+
+func (a axis) scale(v float64) float64 {
+	return a.from + (v-a.min)/(a.max-a.min)*(a.to-a.from)
+}
+
+// niceStep rounds a tick step to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	pow := math.Pow(10, math.Floor(math.Log10(raw)))
+	switch f := raw / pow; {
+	case f <= 1:
+		return pow
+	case f <= 2:
+		return 2 * pow
+	case f <= 5:
+		return 5 * pow
+	}
+	return 10 * pow
+}
+
+func tick(v float64) string {
+	return fmt.Sprintf("%.4g", v)
+}
+
+func (c lineChart) svg() template.HTML {
+	var xs, ys []float64
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			xs = append(xs, p.X)
+			ys = append(ys, p.Y, p.Low, p.High)
+		}
+	}
+	xMin, xMax := bounds(xs)
+	yMin, yMax := bounds(ys)
+	x := newAxis(xMin, xMax, marginLeft, chartWidth-marginRight)
+	y := newAxis(yMin, yMax, chartHeight-marginBottom, marginTop)
+
+	var b strings.Builder
+	height := chartHeight + legendRow*len(c.Series)
+	open(&b, chartWidth, height, c.Title)
+	axes(&b, x, y, c.XLabel, c.YLabel)
+	for i, s := range c.Series {
+		color := palette[i%len(palette)]
+		var path []string
+		for _, p := range s.Points {
+			px, py := x.scale(p.X), y.scale(p.Y)
+			path = append(path, fmt.Sprintf("%.1f,%.1f", px, py))
+			if !math.IsNaN(p.Low) && !math.IsNaN(p.High) {
+				fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s"/>`+"\n",
+					px, y.scale(p.Low), px, y.scale(p.High), color)
+			}
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s: %s</title></circle>`+"\n",
+				px, py, color, html.EscapeString(s.Name), tick(p.Y))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"/>`+"\n", strings.Join(path, " "), color)
+		ly := chartHeight + legendRow*i
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`+"\n", marginLeft, ly, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11">%s</text>`+"\n", marginLeft+16, ly+9, html.EscapeString(s.Name))
+	}
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+type bar struct {
+	Label     string
+	Value     float64
+	Low, High float64
+}
+
+type barChart struct {
+	Title  string
+	YLabel string
+	Bars   []bar
+}
+
+func (c barChart) svg() template.HTML {
+	var ys []float64
+	for _, br := range c.Bars {
+		ys = append(ys, br.Value, br.Low, br.High)
+	}
+	width := chartWidth
+	if w := marginLeft + marginRight + 32*len(c.Bars); w > width {
+		width = w
+	}
+	yMin, yMax := bounds(ys)
+	y := newAxis(yMin, yMax, chartHeight-marginBottom, marginTop)
+	slot := float64(width-marginLeft-marginRight) / float64(len(c.Bars))
+
+	// Labels are rotated under the bars, leave room for the longest.
+	longest := 0
+	for _, br := range c.Bars {
+		if len(br.Label) > longest {
+			longest = len(br.Label)
+		}
+	}
+	height := chartHeight - marginBottom + 20 + int(float64(longest)*labelCharWidth*math.Sin(labelAngle*math.Pi/180))
+
+	var b strings.Builder
+	open(&b, width, height, c.Title)
+	axes(&b, axis{}, y, "", c.YLabel)
+	fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#333"/>`+"\n",
+		marginLeft, y.scale(0), width-marginRight, y.scale(0))
+	for i, br := range c.Bars {
+		left := float64(marginLeft) + slot*float64(i) + slot*0.15
+		mid := left + slot*0.35
+		top, bottom := y.scale(math.Max(br.Value, 0)), y.scale(math.Min(br.Value, 0))
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`+"\n",
+			left, top, slot*0.7, bottom-top, palette[0], html.EscapeString(br.Label), tick(br.Value))
+		if !math.IsNaN(br.Low) && !math.IsNaN(br.High) {
+			fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333"/>`+"\n",
+				mid, y.scale(br.Low), mid, y.scale(br.High))
+		}
+		ly := chartHeight - marginBottom + 12
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="10" text-anchor="end" transform="rotate(-%d %.1f %d)">%s</text>`+"\n",
+			mid, ly, labelAngle, mid, ly, html.EscapeString(br.Label))
+	}
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
+
+func open(b *strings.Builder, width, height int, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(b, `<text x="%d" y="18" font-size="13" font-weight="bold" text-anchor="middle">%s</text>`+"\n",
+		width/2, html.EscapeString(title))
+}
+
+// axes draws the y axis with its grid, and the x axis if it has ticks.
+func axes(b *strings.Builder, x, y axis, xLabel, yLabel string) {
+	right := chartWidth - marginRight
+	if len(x.ticks) > 0 {
+		right = int(x.to)
+	}
+	for _, t := range y.ticks {
+		py := y.scale(t)
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#eee"/>`+"\n", marginLeft, py, right, py)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" font-size="10" text-anchor="end">%s</text>`+"\n", marginLeft-4, py+3, tick(t))
+	}
+	fmt.Fprintf(b, `<text x="14" y="%d" font-size="11" text-anchor="middle" transform="rotate(-90 14 %d)">%s</text>`+"\n",
+		(marginTop+chartHeight-marginBottom)/2, (marginTop+chartHeight-marginBottom)/2, html.EscapeString(yLabel))
+	if len(x.ticks) == 0 {
+		return
+	}
+	bottom := chartHeight - marginBottom
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#333"/>`+"\n", marginLeft, bottom, right, bottom)
+	for _, t := range x.ticks {
+		px := x.scale(t)
+		fmt.Fprintf(b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#333"/>`+"\n", px, bottom, px, bottom+4)
+		fmt.Fprintf(b, `<text x="%.1f" y="%d" font-size="10" text-anchor="middle">%s</text>`+"\n", px, bottom+15, tick(t))
+	}
+	fmt.Fprintf(b, `<text x="%d" y="%d" font-size="11" text-anchor="middle">%s</text>`+"\n",
+		(marginLeft+right)/2, bottom+32, html.EscapeString(xLabel))
+}
+
+// bounds returns the range of the values, ignoring NaNs.
+func bounds(values []float64) (float64, float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		min, max = math.Min(min, v), math.Max(max, v)
+	}
+	if math.IsInf(min, 1) {
+		return 0, 1
+	}
+	return min, max
+}
+
+// heatmap shades every cell by its value relative to the largest one. NaN
+// cells are left empty.
+type heatmap struct {
+	Title  string
+	Rows   []string
+	Cols   []string
+	Values [][]float64
+}
+
+func (h heatmap) svg() template.HTML {
+	const cell, left, top = 44, 150, 110
+	width := left + cell*len(h.Cols) + marginRight
+	height := top + cell*len(h.Rows) + 10
+	var all []float64
+	for _, row := range h.Values {
+		all = append(all, row...)
+	}
+	_, max := bounds(all)
+	if max <= 0 {
+		max = 1
+	}
+
+	var b strings.Builder
+	open(&b, width, height, h.Title)
+	for j, col := range h.Cols {
+		x := left + cell*j + cell/2
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="10" transform="rotate(-45 %d %d)">%s</text>`+"\n",
+			x, top-6, x, top-6, html.EscapeString(col))
+	}
+	for i, row := range h.Rows {
+		y := top + cell*i
+		fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="10" text-anchor="end">%s</text>`+"\n",
+			left-6, y+cell/2+3, html.EscapeString(row))
+		for j, v := range h.Values[i] {
+			if math.IsNaN(v) {
+				continue
+			}
+			x := left + cell*j
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s" fill-opacity="%.2f" stroke="#fff"><title>%s to %s: %s</title></rect>`+"\n",
+				x, y, cell, cell, palette[0], 0.1+0.9*v/max, html.EscapeString(row), html.EscapeString(h.Cols[j]), tick(v))
+			fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="10" text-anchor="middle">%.2f</text>`+"\n", x+cell/2, y+cell/2+3, v)
+		}
+	}
+	b.WriteString("</svg>")
+	return template.HTML(b.String())
+}
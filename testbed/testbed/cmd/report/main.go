@@ -0,0 +1,168 @@
+// Command report writes a self-contained HTML report of the outputs of a
+// testground run, to be attached to RFC pull requests. It plots the time to
+// fetch against the file size, bandwidth and latency, the ratio of duplicate
+// blocks and the fairness of the bitswap ledgers, and lists the parameters
+// the run was made with.
+//
+// Usage:
+//
+//	report [flags] <results dir>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+func main() {
+	out := flag.String("o", "report.html", "output file")
+	title := flag.String("title", "", "title of the report (the name of the results dir by default)")
+	composition := flag.String("composition", "", "composition file to include in the report")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <results dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *out, *title, *composition); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type report struct {
+	Title       string
+	Created     time.Time
+	Groups      []groupParams
+	Dimensions  []dimensionValues
+	Composition string
+	Sections    []section
+}
+
+// section is a part of the report with its charts. Sections without data
+// show the note instead.
+type section struct {
+	Title  string
+	Text   string
+	Charts []template.HTML
+	Note   string
+}
+
+func run(dir, out, title, composition string) error {
+	res, skipped, err := results.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d lines under %s that aren't metrics of this test plan\n", skipped, dir)
+	}
+	if len(res) == 0 {
+		return fmt.Errorf("No results under %s", dir)
+	}
+	groups, err := readParams(dir)
+	if err != nil {
+		return err
+	}
+
+	r := report{
+		Title:      title,
+		Created:    time.Now().UTC(),
+		Groups:     groups,
+		Dimensions: dimensions(res),
+		Sections:   []section{timeToFetch(res), duplicates(res), fairness(res)},
+	}
+	if r.Title == "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		r.Title = filepath.Base(abs)
+	}
+	if composition != "" {
+		data, err := ioutil.ReadFile(composition)
+		if err != nil {
+			return err
+		}
+		r.Composition = string(data)
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := page.Execute(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("Error writing %s: %w", out, err)
+	}
+	return f.Close()
+}
+
+var page = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 1100px; color: #222; }
+h1 { margin-bottom: 0; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: .2em; margin-top: 2em; }
+.created { color: #777; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ddd; padding: .3em .6em; text-align: left; font-size: 90%; }
+th { background: #f4f4f4; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; }
+.note { color: #777; font-style: italic; }
+svg text { font-family: sans-serif; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="created">Generated {{.Created.Format "2006-01-02 15:04 MST"}}</p>
+
+<h2>Parameters</h2>
+{{range .Groups}}
+<h3>Group {{.Group}}</h3>
+<p>Test case <code>{{.Plan}}/{{.Case}}</code>, {{.Instances}} instances.</p>
+<table>
+<tr><th>parameter</th><th>value</th></tr>
+{{range .Params}}<tr><td>{{.Key}}</td><td>{{.Value}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="note">No run environment found in the run.out files of the instances.</p>
+{{end}}
+<h3>Dimensions</h3>
+<table>
+<tr><th>dimension</th><th>values</th></tr>
+{{range .Dimensions}}<tr><td>{{.Key}}</td><td>{{.Values}}</td></tr>
+{{end}}
+</table>
+{{with .Composition}}
+<h3>Composition</h3>
+<pre>{{.}}</pre>
+{{end}}
+
+{{range .Sections}}
+<h2>{{.Title}}</h2>
+<p>{{.Text}}</p>
+{{with .Note}}<p class="note">{{.}}</p>{{end}}
+<div class="charts">
+{{range .Charts}}{{.}}
+{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
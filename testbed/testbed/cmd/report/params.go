@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+// runFile is the name of the files testground writes the events of an
+// instance to. The first event is the run environment of the instance.
+const runFile = "run.out"
+
+type param struct {
+	Key   string
+	Value string
+}
+
+// groupParams are the parameters the instances of a group were run with.
+type groupParams struct {
+	Group     string
+	Plan      string
+	Case      string
+	Instances int
+	Params    []param
+}
+
+// event is a line of a run.out file. Only start events carry the run
+// environment.
+type event struct {
+	Event struct {
+		Runenv *struct {
+			Plan      string            `json:"plan"`
+			Case      string            `json:"case"`
+			Instances int               `json:"instances"`
+			Group     string            `json:"group"`
+			Params    map[string]string `json:"params"`
+		} `json:"runenv"`
+	} `json:"event"`
+}
+
+// readParams reads the run environment of the first instance of every group
+// under dir.
+func readParams(dir string) ([]groupParams, error) {
+	byGroup := make(map[string]groupParams)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != runFile {
+			return nil
+		}
+		g, ok, err := readRunenv(path)
+		if err != nil || !ok {
+			return err
+		}
+		if _, seen := byGroup[g.Group]; !seen {
+			byGroup[g.Group] = g
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]groupParams, 0, len(byGroup))
+	for _, g := range byGroup {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Group < groups[j].Group })
+	return groups, nil
+}
+
+func readRunenv(path string) (groupParams, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return groupParams{}, false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e event
+		// Lines written by the instance itself are not necessarily JSON.
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.Event.Runenv == nil {
+			continue
+		}
+		re := e.Event.Runenv
+		g := groupParams{Group: re.Group, Plan: re.Plan, Case: re.Case, Instances: re.Instances}
+		for k, v := range re.Params {
+			g.Params = append(g.Params, param{k, v})
+		}
+		sort.Slice(g.Params, func(i, j int) bool { return g.Params[i].Key < g.Params[j].Key })
+		return g, true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return groupParams{}, false, fmt.Errorf("Error reading %s: %w", path, err)
+	}
+	return groupParams{}, false, nil
+}
+
+type dimensionValues struct {
+	Key    string
+	Values string
+}
+
+// Dimensions that identify single runs or nodes rather than the experiment.
+var skipDimensions = map[string]bool{
+	"run":           true,
+	"seq":           true,
+	"groupSeq":      true,
+	"nodeTypeIndex": true,
+	"peerTypeIndex": true,
+	"fetchCid":      true,
+}
+
+// Dimensions with more distinct values than this only show how many there
+// are.
+const maxDimensionValues = 12
+
+// dimensions lists the distinct values of every dimension of the results,
+// with the number of runs.
+func dimensions(res []results.Result) []dimensionValues {
+	var keys []string
+	values := make(map[string]map[string]bool)
+	runs := make(map[string]bool)
+	for _, r := range res {
+		runs[strconv.Itoa(r.Run)] = true
+		for _, s := range r.Segments() {
+			if skipDimensions[s.Key] {
+				continue
+			}
+			if _, ok := values[s.Key]; !ok {
+				keys = append(keys, s.Key)
+				values[s.Key] = make(map[string]bool)
+			}
+			values[s.Key][s.Value] = true
+		}
+	}
+
+	out := []dimensionValues{{"runs", strconv.Itoa(len(runs))}}
+	for _, k := range keys {
+		vs := make([]string, 0, len(values[k]))
+		for v := range values[k] {
+			vs = append(vs, v)
+		}
+		if len(vs) > maxDimensionValues {
+			out = append(out, dimensionValues{k, fmt.Sprintf("%d values", len(vs))})
+			continue
+		}
+		sortValues(vs)
+		out = append(out, dimensionValues{k, strings.Join(vs, ", ")})
+	}
+	return out
+}
+
+// sortValues sorts numbers numerically, before any other value.
+func sortValues(vs []string) {
+	sort.Slice(vs, func(i, j int) bool { return lessValue(vs[i], vs[j]) })
+}
+
+func lessValue(a, b string) bool {
+	x, xErr := strconv.ParseFloat(a, 64)
+	y, yErr := strconv.ParseFloat(b, 64)
+	switch {
+	case xErr == nil && yErr == nil:
+		return x < y
+	case xErr == nil:
+		return true
+	case yErr == nil:
+		return false
+	}
+	return a < b
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+const confidence = 0.95
+
+// Dimensions the experiments of a run vary over. Lines and bars are only
+// split by the ones that take more than one value.
+var experimentDimensions = []string{"topology", "transport", "fileSize", "bandwidthMB", "latencyMS"}
+
+func timeToFetch(res []results.Result) section {
+	s := section{
+		Title: "Time to fetch",
+		Text: "Mean time for leeches to fetch the file, with the 95% confidence interval of the mean. " +
+			"Failed fetches are left out.",
+	}
+	var fetches []results.Result
+	for _, r := range res {
+		if r.Name == "time_to_fetch" && r.NodeType == "Leech" && r.Value > 0 {
+			fetches = append(fetches, r)
+		}
+	}
+	if len(fetches) == 0 {
+		s.Note = "No time_to_fetch metrics of leeches in these results."
+		return s
+	}
+
+	varying := varyingDimensions(fetches)
+	axes := []struct {
+		key   string
+		label string
+		scale float64
+	}{
+		{"fileSize", "file size (MB)", 1e6},
+		{"bandwidthMB", "bandwidth (MB)", 1},
+		{"latencyMS", "latency (ms)", 1},
+	}
+	for i, a := range axes {
+		// With a single experiment, plot it by file size.
+		if !contains(varying, a.key) && (len(varying) > 0 || i > 0) {
+			continue
+		}
+		c := lineChart{
+			Title:  "Time to fetch by " + a.key,
+			XLabel: a.label,
+			YLabel: "time to fetch (ms)",
+			Series: lines(fetches, a.key, a.scale, 1e6, without(varying, a.key)),
+		}
+		s.Charts = append(s.Charts, c.svg())
+	}
+	return s
+}
+
+// lines groups the values by the given dimensions into series, and the values
+// of every series by the x dimension. Values are divided by the scales.
+func lines(res []results.Result, x string, xScale, yScale float64, by []string) []lineSeries {
+	values := make(map[string]map[float64][]float64)
+	var names []string
+	for _, r := range res {
+		name := label(r.Dimensions, by)
+		xv, _ := r.Get(x)
+		f, err := strconv.ParseFloat(xv, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := values[name]; !ok {
+			values[name] = make(map[float64][]float64)
+			names = append(names, name)
+		}
+		values[name][f/xScale] = append(values[name][f/xScale], r.Value/yScale)
+	}
+	sortLabels(names)
+
+	var series []lineSeries
+	for _, name := range names {
+		s := lineSeries{Name: name}
+		for xv, ys := range values[name] {
+			sum := results.Summarize(ys, confidence)
+			s.Points = append(s.Points, point{xv, sum.Mean, sum.CILow, sum.CIHigh})
+		}
+		sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].X < s.Points[j].X })
+		series = append(series, s)
+	}
+	return series
+}
+
+func duplicates(res []results.Result) section {
+	s := section{
+		Title: "Duplicate blocks",
+		Text:  "Mean fraction of the blocks received by leeches that were duplicates, with the 95% confidence interval of the mean.",
+	}
+	received := make(map[string]float64)
+	for _, r := range res {
+		if r.Name == "blks_rcvd" && r.NodeType == "Leech" {
+			received[r.ID("")] = r.Value
+		}
+	}
+	var ratios []results.Result
+	for _, r := range res {
+		if r.Name != "dup_blks_rcvd" || r.NodeType != "Leech" {
+			continue
+		}
+		if blocks := received[r.ID("")]; blocks > 0 {
+			r.Value /= blocks
+			ratios = append(ratios, r)
+		}
+	}
+	if len(ratios) == 0 {
+		s.Note = "No block counts of leeches in these results."
+		return s
+	}
+
+	c := barChart{Title: "Duplicate block ratio", YLabel: "duplicate blocks / blocks received"}
+	c.Bars = bars(ratios, varyingDimensions(ratios))
+	s.Charts = append(s.Charts, c.svg())
+	return s
+}
+
+func fairness(res []results.Result) section {
+	s := section{
+		Title: "Fairness",
+		Text: "How evenly every bitswap node shared its upload between its peers during a run (Jain's index over the " +
+			"throughput to each peer, 1 when all of them were served equally), how much it sent for what it received, " +
+			"and the time-weighted share of its upload that went to each peer.",
+	}
+	var jain, contribution, share []results.Result
+	for _, r := range res {
+		_, perPeer := r.Get("peerType")
+		switch {
+		case r.Name == "jain_fairness":
+			jain = append(jain, r)
+		case r.Name == "contribution_ratio" && !perPeer:
+			contribution = append(contribution, r)
+		case r.Name == "service_share" && perPeer:
+			share = append(share, r)
+		}
+	}
+	if len(jain)+len(contribution)+len(share) == 0 {
+		s.Note = "No fairness metrics in these results. They are recorded by bitswap nodes in the transfer and trade test cases."
+		return s
+	}
+
+	// Nodes are compared over all the experiments of a topology.
+	byNode := func(res []results.Result) []string {
+		var by []string
+		for _, k := range varyingDimensions(res) {
+			if k == "topology" || k == "transport" {
+				by = append(by, k)
+			}
+		}
+		return append(by, "node")
+	}
+	if len(jain) > 0 {
+		c := barChart{Title: "Jain's fairness index", YLabel: "fairness index"}
+		c.Bars = bars(jain, byNode(jain))
+		s.Charts = append(s.Charts, c.svg())
+	}
+	if len(contribution) > 0 {
+		c := barChart{Title: "Contribution ratio", YLabel: "bytes sent / bytes received"}
+		c.Bars = bars(contribution, byNode(contribution))
+		s.Charts = append(s.Charts, c.svg())
+	}
+	if len(share) > 0 {
+		s.Charts = append(s.Charts, serviceShares(share))
+	}
+	return s
+}
+
+// serviceShares draws the mean share of the upload of every node that went
+// to each of its peers.
+func serviceShares(res []results.Result) template.HTML {
+	values := make(map[[2]string][]float64)
+	rows := make(map[string]bool)
+	cols := make(map[string]bool)
+	for _, r := range res {
+		peerType, _ := r.Get("peerType")
+		peerIndex, _ := r.Get("peerTypeIndex")
+		row, col := nodeName(r.Dimensions), peerType+" "+peerIndex
+		rows[row], cols[col] = true, true
+		values[[2]string{row, col}] = append(values[[2]string{row, col}], r.Value)
+	}
+	h := heatmap{Title: "Service share (row to column)", Rows: keys(rows), Cols: keys(cols)}
+	for _, row := range h.Rows {
+		line := make([]float64, len(h.Cols))
+		for i, col := range h.Cols {
+			line[i] = math.NaN()
+			if vs, ok := values[[2]string{row, col}]; ok {
+				line[i] = results.Summarize(vs, confidence).Mean
+			}
+		}
+		h.Values = append(h.Values, line)
+	}
+	return h.svg()
+}
+
+// bars groups the values by the given dimensions, "node" being the type and
+// index of the node that recorded them.
+func bars(res []results.Result, by []string) []bar {
+	values := make(map[string][]float64)
+	var labels []string
+	for _, r := range res {
+		l := label(r.Dimensions, by)
+		if _, ok := values[l]; !ok {
+			labels = append(labels, l)
+		}
+		values[l] = append(values[l], r.Value)
+	}
+	sortLabels(labels)
+	out := make([]bar, len(labels))
+	for i, l := range labels {
+		sum := results.Summarize(values[l], confidence)
+		out[i] = bar{l, sum.Mean, sum.CILow, sum.CIHigh}
+	}
+	return out
+}
+
+// varyingDimensions returns the experiment dimensions with more than one
+// value in the results.
+func varyingDimensions(res []results.Result) []string {
+	var out []string
+	for _, k := range experimentDimensions {
+		seen := make(map[string]bool)
+		for _, r := range res {
+			v, _ := r.Get(k)
+			seen[v] = true
+		}
+		if len(seen) > 1 {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func label(d results.Dimensions, by []string) string {
+	if len(by) == 0 {
+		return "all"
+	}
+	parts := make([]string, len(by))
+	for i, k := range by {
+		if k == "node" {
+			parts[i] = nodeName(d)
+			continue
+		}
+		v, _ := d.Get(k)
+		parts[i] = fmt.Sprintf("%s:%s", k, v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func nodeName(d results.Dimensions) string {
+	name := fmt.Sprintf("%s %d", d.NodeType, d.NodeTypeIndex)
+	if b, ok := d.Get("behaviour"); ok {
+		name += " (" + b + ")"
+	}
+	return name
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, s string) []string {
+	var out []string
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func keys(set map[string]bool) []string {
+	out := make([]string, 0, len(set))
+	for k := range set {
+		out = append(out, k)
+	}
+	sortLabels(out)
+	return out
+}
+
+// sortLabels sorts labels word by word, comparing the numeric values of
+// dimensions as numbers.
+func sortLabels(labels []string) {
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := strings.Fields(labels[i]), strings.Fields(labels[j])
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] == b[k] {
+				continue
+			}
+			if va, vb := value(a[k]), value(b[k]); va != vb {
+				return lessValue(va, vb)
+			}
+			return a[k] < b[k]
+		}
+		return len(a) < len(b)
+	})
+}
+
+// value returns the value of a key:value word.
+func value(word string) string {
+	return word[strings.LastIndex(word, ":")+1:]
+}
@@ -0,0 +1,63 @@
+// Command workload assembles the trace of a fetch workload from the records
+// leeches write with record_workload set, for the replay test case to replay.
+// Every run of an experiment is a workload of its own; without -run, the
+// runs found are listed.
+//
+// Usage:
+//
+//	workload [flags] <outputs dir>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/workload"
+)
+
+func main() {
+	run := flag.String("run", "", "run to assemble the trace of, as <permutation>-<run>, e.g. 0-1")
+	out := flag.String("o", "workload.json", "output file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <outputs dir>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := assemble(flag.Arg(0), *run, *out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func assemble(dir, run, out string) error {
+	records, err := workload.ReadRecords(dir)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("No workload records under %s, were they recorded with record_workload=true?", dir)
+	}
+	if run == "" {
+		fmt.Println("Runs with workload records (pick one with -run):")
+		for _, r := range workload.Runs(records) {
+			fmt.Println(r)
+		}
+		return nil
+	}
+
+	trace, err := workload.Assemble(records, run)
+	if err != nil {
+		return err
+	}
+	if err := trace.WriteFile(out); err != nil {
+		return err
+	}
+	fmt.Printf("Workload of %d requests of %d items written to %s\n", len(trace.Requests), len(trace.Items), out)
+	return nil
+}
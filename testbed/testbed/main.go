@@ -10,5 +10,7 @@ func main() {
 		"transfer":     test.Transfer,
 		"tcp-transfer": test.TCPTransfer,
 		"trade":        test.Trade,
+		"catalog":      test.Catalog,
+		"replay":       test.Replay,
 	})
 }
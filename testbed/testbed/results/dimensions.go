@@ -0,0 +1,155 @@
+// Package results defines the identity of the metrics recorded by the test
+// cases, and reads them back from the results.out files of testground runs.
+package results
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tag is an additional dimension of a metric, such as the behaviour of a
+// peer or the protocol of a bandwidth measurement.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Dimensions identify the experiment, run and node a metric comes from.
+// Metric IDs are the dimensions as key:value segments separated by '/', with
+// the tags after the fixed dimensions and the metric name last, e.g.
+//
+//	topology:(1-2-0)/transport:bitswap/.../nodeTypeIndex:0/behaviour:honest/name:time_to_fetch
+type Dimensions struct {
+	Seeds             int
+	Leeches           int
+	Passives          int
+	Transport         string
+	MaxConnectionRate int
+	LatencyMS         int64
+	BandwidthMB       int
+	Run               int
+	Seq               int64
+	GroupName         string
+	GroupSeq          int64
+	FileSize          int
+	NodeType          string
+	NodeTypeIndex     int
+	Tags              []Tag
+}
+
+const (
+	topologyKey = "topology"
+	nameKey     = "name"
+)
+
+// sanitize keeps the separators of metric IDs out of a value.
+var sanitize = strings.NewReplacer("/", "_", ":", "_").Replace
+
+// With returns the dimensions with an additional tag. Separators in the value
+// are replaced with '_'.
+func (d Dimensions) With(key string, value interface{}) Dimensions {
+	tags := make([]Tag, len(d.Tags), len(d.Tags)+1)
+	copy(tags, d.Tags)
+	d.Tags = append(tags, Tag{key, sanitize(fmt.Sprint(value))})
+	return d
+}
+
+// Segments returns the dimensions as key/value pairs, in the order they are
+// written to metric IDs.
+func (d Dimensions) Segments() []Tag {
+	segments := []Tag{
+		{topologyKey, fmt.Sprintf("(%d-%d-%d)", d.Seeds, d.Leeches, d.Passives)},
+		{"transport", sanitize(d.Transport)},
+		{"maxConnectionRate", strconv.Itoa(d.MaxConnectionRate)},
+		{"latencyMS", strconv.FormatInt(d.LatencyMS, 10)},
+		{"bandwidthMB", strconv.Itoa(d.BandwidthMB)},
+		{"run", strconv.Itoa(d.Run)},
+		{"seq", strconv.FormatInt(d.Seq, 10)},
+		{"groupName", sanitize(d.GroupName)},
+		{"groupSeq", strconv.FormatInt(d.GroupSeq, 10)},
+		{"fileSize", strconv.Itoa(d.FileSize)},
+		{"nodeType", sanitize(d.NodeType)},
+		{"nodeTypeIndex", strconv.Itoa(d.NodeTypeIndex)},
+	}
+	return append(segments, d.Tags...)
+}
+
+// Get returns the value of a dimension or tag by its key in metric IDs.
+func (d Dimensions) Get(key string) (string, bool) {
+	for _, s := range d.Segments() {
+		if s.Key == key {
+			return s.Value, true
+		}
+	}
+	return "", false
+}
+
+// ID returns the ID of the metric with the given name.
+func (d Dimensions) ID(name string) string {
+	var b strings.Builder
+	for _, s := range d.Segments() {
+		b.WriteString(s.Key)
+		b.WriteByte(':')
+		b.WriteString(s.Value)
+		b.WriteByte('/')
+	}
+	b.WriteString(nameKey)
+	b.WriteByte(':')
+	b.WriteString(name)
+	return b.String()
+}
+
+// ParseID returns the dimensions and name of a metric ID. Missing dimensions
+// are left at their zero value and unknown keys are kept as tags, so IDs
+// written before or after a dimension was added can still be read.
+func ParseID(id string) (Dimensions, string, error) {
+	var d Dimensions
+	var name string
+	var hasName bool
+	for _, segment := range strings.Split(id, "/") {
+		kv := strings.SplitN(segment, ":", 2)
+		if len(kv) != 2 {
+			return Dimensions{}, "", fmt.Errorf("Invalid segment '%s' in metric %s", segment, id)
+		}
+		key, value := kv[0], kv[1]
+		var err error
+		switch key {
+		case nameKey:
+			name, hasName = value, true
+		case topologyKey:
+			_, err = fmt.Sscanf(value, "(%d-%d-%d)", &d.Seeds, &d.Leeches, &d.Passives)
+		case "transport":
+			d.Transport = value
+		case "maxConnectionRate":
+			d.MaxConnectionRate, err = strconv.Atoi(value)
+		case "latencyMS":
+			d.LatencyMS, err = strconv.ParseInt(value, 10, 64)
+		case "bandwidthMB":
+			d.BandwidthMB, err = strconv.Atoi(value)
+		case "run":
+			d.Run, err = strconv.Atoi(value)
+		case "seq":
+			d.Seq, err = strconv.ParseInt(value, 10, 64)
+		case "groupName":
+			d.GroupName = value
+		case "groupSeq":
+			d.GroupSeq, err = strconv.ParseInt(value, 10, 64)
+		case "fileSize":
+			d.FileSize, err = strconv.Atoi(value)
+		case "nodeType":
+			d.NodeType = value
+		case "nodeTypeIndex":
+			d.NodeTypeIndex, err = strconv.Atoi(value)
+		default:
+			d.Tags = append(d.Tags, Tag{key, value})
+		}
+		if err != nil {
+			return Dimensions{}, "", fmt.Errorf("Invalid %s '%s' in metric %s: %w", key, value, id, err)
+		}
+	}
+	if !hasName {
+		return Dimensions{}, "", fmt.Errorf("Metric %s has no name", id)
+	}
+	return d, name, nil
+}
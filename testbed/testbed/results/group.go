@@ -0,0 +1,72 @@
+package results
+
+import (
+	"sort"
+	"strings"
+)
+
+// Series are the values of a metric in a group of results.
+type Series struct {
+	// The dimensions the group was made by, as key:value segments separated
+	// by '/', e.g. "latencyMS:5/fileSize:1048576".
+	Group  string
+	Metric string
+	Values []float64
+}
+
+// GroupBy collects the values of every metric by the given dimensions.
+// Results missing one of them are grouped under an empty value. Series are
+// sorted by group and metric.
+func GroupBy(res []Result, keys []string) []Series {
+	index := make(map[[2]string]*Series)
+	var series []*Series
+	for _, r := range res {
+		group := groupOf(r.Dimensions, keys)
+		k := [2]string{group, r.Name}
+		s, ok := index[k]
+		if !ok {
+			s = &Series{Group: group, Metric: r.Name}
+			index[k] = s
+			series = append(series, s)
+		}
+		s.Values = append(s.Values, r.Value)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Group != series[j].Group {
+			return series[i].Group < series[j].Group
+		}
+		return series[i].Metric < series[j].Metric
+	})
+	out := make([]Series, len(series))
+	for i, s := range series {
+		out[i] = *s
+	}
+	return out
+}
+
+func groupOf(d Dimensions, keys []string) string {
+	segments := make([]string, len(keys))
+	for i, k := range keys {
+		v, _ := d.Get(k)
+		segments[i] = k + ":" + v
+	}
+	return strings.Join(segments, "/")
+}
+
+// Filter returns the results matching every given dimension value.
+func Filter(res []Result, match map[string]string) []Result {
+	var out []Result
+	for _, r := range res {
+		ok := true
+		for k, want := range match {
+			if v, _ := r.Get(k); v != want {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package results
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ResultsFile is the name of the files testground writes the metrics of an
+// instance to.
+const ResultsFile = "results.out"
+
+// maxLineSize is the longest line of a results file Read accepts.
+const maxLineSize = 1 << 20
+
+// Result is a metric recorded by a test instance.
+type Result struct {
+	Dimensions
+	Name  string
+	Value float64
+	Time  time.Time
+}
+
+// point is a line of a results.out file.
+type point struct {
+	Timestamp int64  `json:"ts"`
+	Name      string `json:"name"`
+	Measures  struct {
+		Value float64 `json:"value"`
+	} `json:"measures"`
+}
+
+// Read reads the metrics of a results.out file. Lines that aren't metrics of
+// this test plan, such as the ones other test plans or older versions of this
+// one recorded under different names, are skipped and counted.
+func Read(r io.Reader) ([]Result, int, error) {
+	var res []Result
+	skipped := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var p point
+		if err := json.Unmarshal(line, &p); err != nil {
+			skipped++
+			continue
+		}
+		d, name, err := ParseID(p.Name)
+		if err != nil {
+			skipped++
+			continue
+		}
+		res = append(res, Result{d, name, p.Measures.Value, time.Unix(0, p.Timestamp)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("Error reading results: %w", err)
+	}
+	return res, skipped, nil
+}
+
+// ReadFile reads the metrics of the results.out file at path, and counts the
+// lines skipped.
+func ReadFile(path string) ([]Result, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	res, skipped, err := Read(f)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return res, skipped, nil
+}
+
+// ReadDir reads the metrics of every results.out file under dir, such as the
+// outputs collected from a testground run, and counts the lines skipped.
+func ReadDir(dir string) ([]Result, int, error) {
+	var res []Result
+	skipped := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != ResultsFile {
+			return nil
+		}
+		fileRes, fileSkipped, err := ReadFile(path)
+		if err != nil {
+			return err
+		}
+		res = append(res, fileRes...)
+		skipped += fileSkipped
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return res, skipped, nil
+}
@@ -0,0 +1,223 @@
+package results
+
+import (
+	"math"
+	"sort"
+)
+
+// Summary describes a sample of values of a metric.
+type Summary struct {
+	N      int
+	Mean   float64
+	StdDev float64
+	Min    float64
+	Median float64
+	P90    float64
+	P99    float64
+	Max    float64
+	// Confidence interval of the mean. NaN with fewer than two values.
+	CILow  float64
+	CIHigh float64
+}
+
+// Summarize describes values, with a confidence interval of the mean at the
+// given level (e.g. 0.95).
+func Summarize(values []float64, confidence float64) Summary {
+	s := Summary{N: len(values), CILow: math.NaN(), CIHigh: math.NaN()}
+	if s.N == 0 {
+		s.Mean, s.StdDev, s.Min, s.Median, s.P90, s.P99, s.Max = math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()
+		return s
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	s.Mean = mean(sorted)
+	s.StdDev = math.Sqrt(variance(sorted, s.Mean))
+	s.Min, s.Max = sorted[0], sorted[s.N-1]
+	s.Median = Percentile(sorted, 50)
+	s.P90 = Percentile(sorted, 90)
+	s.P99 = Percentile(sorted, 99)
+	if s.N > 1 {
+		margin := tQuantile(confidence, float64(s.N-1)) * s.StdDev / math.Sqrt(float64(s.N))
+		s.CILow, s.CIHigh = s.Mean-margin, s.Mean+margin
+	}
+	return s
+}
+
+// Percentile returns the p-th percentile (0 to 100) of sorted values,
+// interpolating between the closest ranks.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo < 0 {
+		return sorted[0]
+	}
+	if hi >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// variance returns the sample variance of values, 0 for a single value.
+func variance(values []float64, mean float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return sum / float64(len(values)-1)
+}
+
+// Comparison compares the values of a metric in a candidate against a
+// baseline.
+type Comparison struct {
+	Baseline  Summary
+	Candidate Summary
+	// Difference of the means, absolute and relative to the baseline.
+	Diff    float64
+	RelDiff float64
+	// Two-sided p-value of Welch's t-test. NaN with fewer than two values on
+	// either side.
+	PValue float64
+}
+
+// Compare summarizes both samples and tests whether their means differ.
+func Compare(baseline, candidate []float64, confidence float64) Comparison {
+	c := Comparison{
+		Baseline:  Summarize(baseline, confidence),
+		Candidate: Summarize(candidate, confidence),
+	}
+	c.Diff = c.Candidate.Mean - c.Baseline.Mean
+	c.RelDiff = c.Diff / c.Baseline.Mean
+	c.PValue = WelchTTest(baseline, candidate)
+	return c
+}
+
+// Significant reports whether the difference is significant at level alpha.
+func (c Comparison) Significant(alpha float64) bool {
+	return !math.IsNaN(c.PValue) && c.PValue < alpha
+}
+
+// WelchTTest returns the two-sided p-value of Welch's t-test for the
+// difference of the means of a and b.
+func WelchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return math.NaN()
+	}
+	ma, mb := mean(a), mean(b)
+	va := variance(a, ma) / float64(len(a))
+	vb := variance(b, mb) / float64(len(b))
+	if va+vb == 0 {
+		// Both samples are constant.
+		if ma == mb {
+			return 1
+		}
+		return 0
+	}
+	t := (ma - mb) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(len(a)-1) + vb*vb/float64(len(b)-1))
+	return tTwoSided(t, df)
+}
+
+// tTwoSided returns P(|T| > |t|) for Student's t distribution with df degrees
+// of freedom.
+func tTwoSided(t, df float64) float64 {
+	return incompleteBeta(df/(df+t*t), df/2, 0.5)
+}
+
+// tQuantile returns the t such that P(|T| <= t) = confidence.
+func tQuantile(confidence, df float64) float64 {
+	lo, hi := 0.0, 1.0
+	for tTwoSided(hi, df) > 1-confidence {
+		hi *= 2
+	}
+	for i := 0; i < 100; i++ {
+		mid := (lo + hi) / 2
+		if tTwoSided(mid, df) > 1-confidence {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// incompleteBeta returns the regularized incomplete beta function I_x(a, b).
+func incompleteBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly on this side only.
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(x, a, b) / a
+	}
+	return 1 - front*betaFraction(1-x, b, a)/b
+}
+
+// betaFraction evaluates the continued fraction of the incomplete beta
+// function with the modified Lentz method.
+func betaFraction(x, a, b float64) float64 {
+	const (
+		maxIter = 300
+		eps     = 1e-14
+		tiny    = 1e-300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIter; m++ {
+		fm := float64(m)
+		// Even step.
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		// Odd step.
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h
+}
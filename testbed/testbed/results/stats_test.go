@@ -0,0 +1,90 @@
+package results
+
+import (
+	"math"
+	"testing"
+)
+
+// Critical values of Student's t distribution from the usual tables.
+var tTable = []struct {
+	df         float64
+	t          float64
+	confidence float64
+}{
+	{1, 12.706, 0.95},
+	{5, 2.571, 0.95},
+	{5, 4.032, 0.99},
+	{10, 2.228, 0.95},
+	{10, 3.169, 0.99},
+	{30, 2.042, 0.95},
+	{100, 1.984, 0.95},
+}
+
+func TestTTwoSided(t *testing.T) {
+	for _, tc := range tTable {
+		p := tTwoSided(tc.t, tc.df)
+		if math.Abs(p-(1-tc.confidence)) > 1e-4 {
+			t.Errorf("P(|T| > %v) with %v df = %v, want %v", tc.t, tc.df, p, 1-tc.confidence)
+		}
+		if p := tTwoSided(-tc.t, tc.df); math.Abs(p-(1-tc.confidence)) > 1e-4 {
+			t.Errorf("P(|T| > %v) with %v df = %v, want %v", -tc.t, tc.df, p, 1-tc.confidence)
+		}
+	}
+	if p := tTwoSided(0, 10); p != 1 {
+		t.Errorf("P(|T| > 0) = %v, want 1", p)
+	}
+}
+
+func TestTQuantile(t *testing.T) {
+	for _, tc := range tTable {
+		q := tQuantile(tc.confidence, tc.df)
+		if math.Abs(q-tc.t) > 1e-3 {
+			t.Errorf("%v quantile with %v df = %v, want %v", tc.confidence, tc.df, q, tc.t)
+		}
+	}
+}
+
+func TestIncompleteBeta(t *testing.T) {
+	cases := []struct {
+		x, a, b float64
+		want    float64
+	}{
+		{0, 2, 3, 0},
+		{1, 2, 3, 1},
+		// I_x(1, 1) is the uniform distribution.
+		{0.3, 1, 1, 0.3},
+		// I_x(a, 1) = x^a and I_x(1, b) = 1 - (1-x)^b.
+		{0.5, 3, 1, 0.125},
+		{0.5, 1, 3, 0.875},
+		// Symmetry: I_0.5(a, a) = 0.5.
+		{0.5, 7, 7, 0.5},
+	}
+	for _, tc := range cases {
+		if got := incompleteBeta(tc.x, tc.a, tc.b); math.Abs(got-tc.want) > 1e-10 {
+			t.Errorf("I_%v(%v, %v) = %v, want %v", tc.x, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestWelchTTest(t *testing.T) {
+	// The sleep data of R's t.test example: t = -1.8608, df = 17.776,
+	// p-value = 0.07939.
+	a := []float64{0.7, -1.6, -0.2, -1.2, -0.1, 3.4, 3.7, 0.8, 0.0, 2.0}
+	b := []float64{1.9, 0.8, 1.1, 0.1, -0.1, 4.4, 5.5, 1.6, 4.6, 3.4}
+	if p := WelchTTest(a, b); math.Abs(p-0.07939) > 1e-4 {
+		t.Errorf("p-value = %v, want 0.07939", p)
+	}
+	if p := WelchTTest(b, a); math.Abs(p-0.07939) > 1e-4 {
+		t.Errorf("p-value = %v, want 0.07939", p)
+	}
+
+	if p := WelchTTest([]float64{1}, b); !math.IsNaN(p) {
+		t.Errorf("p-value of a single value = %v, want NaN", p)
+	}
+	if p := WelchTTest([]float64{2, 2}, []float64{2, 2, 2}); p != 1 {
+		t.Errorf("p-value of equal constant samples = %v, want 1", p)
+	}
+	if p := WelchTTest([]float64{1, 1}, []float64{2, 2, 2}); p != 0 {
+		t.Errorf("p-value of different constant samples = %v, want 0", p)
+	}
+}
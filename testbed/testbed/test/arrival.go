@@ -0,0 +1,204 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/testground/sdk-go/runtime"
+)
+
+// arrivalModel decides when each leech starts requesting content. Offsets are
+// relative to the moment every node is ready to start the transfer, and are
+// computed independently by each leech, so a model must be deterministic for a
+// given leech index.
+type arrivalModel interface {
+	startOffset(leechIndex int) time.Duration
+}
+
+func newArrivalModel(runenv *runtime.RunEnv, tv *TestVars) (arrivalModel, error) {
+	seed := int64(0)
+	if runenv.IsParamSet("arrival_seed") {
+		seed = int64(runenv.IntParam("arrival_seed"))
+	}
+
+	switch tv.ArrivalModel {
+	case "", "waves":
+		numWaves := tv.NumWaves
+		if numWaves < 1 {
+			numWaves = 1
+		}
+		interval := 5 * time.Second
+		if runenv.IsParamSet("wave_interval_ms") {
+			interval = time.Duration(runenv.IntParam("wave_interval_ms")) * time.Millisecond
+		}
+		return &wavesArrival{numWaves, interval, tv.RequestStagger}, nil
+	case "poisson":
+		rate, err := arrivalRate(runenv)
+		if err != nil {
+			return nil, err
+		}
+		return &poissonArrival{rate, seed}, nil
+	case "flash":
+		window := time.Duration(runenv.IntParam("arrival_window_ms")) * time.Millisecond
+		return &flashCrowdArrival{window, seed}, nil
+	case "diurnal":
+		rate, err := arrivalRate(runenv)
+		if err != nil {
+			return nil, err
+		}
+		period := time.Duration(runenv.IntParam("arrival_period_secs")) * time.Second
+		if period <= 0 {
+			return nil, fmt.Errorf("diurnal arrivals need a positive arrival_period_secs")
+		}
+		amplitude := float64(runenv.IntParam("arrival_amplitude_pct")) / 100
+		if amplitude < 0 || amplitude > 1 {
+			return nil, fmt.Errorf("arrival_amplitude_pct must be between 0 and 100")
+		}
+		return &diurnalArrival{rate, period, amplitude, seed}, nil
+	case "trace":
+		path := runenv.StringParam("arrival_trace")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(runenv.StringParam("data_dir"), path)
+		}
+		offsets, err := readArrivalTrace(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(offsets) < tv.LeechCount {
+			return nil, fmt.Errorf("arrival trace %s has %d offsets for %d leeches", path, len(offsets), tv.LeechCount)
+		}
+		return &traceArrival{offsets}, nil
+	default:
+		return nil, fmt.Errorf("Arrival model %s not implemented", tv.ArrivalModel)
+	}
+}
+
+func arrivalRate(runenv *runtime.RunEnv) (float64, error) {
+	rate, err := strconv.ParseFloat(runenv.StringParam("arrival_rate"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid arrival rate %s", runenv.StringParam("arrival_rate"))
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("arrival_rate must be positive")
+	}
+	return rate, nil
+}
+
+// wavesArrival splits leeches into waves by type index. Each wave starts a
+// fixed interval after the previous one, and leeches are staggered within the
+// run by request_stagger.
+type wavesArrival struct {
+	numWaves int
+	interval time.Duration
+	stagger  time.Duration
+}
+
+func (a *wavesArrival) startOffset(leechIndex int) time.Duration {
+	wave := leechIndex % a.numWaves
+	return time.Duration(wave)*a.interval + time.Duration(leechIndex)*a.stagger
+}
+
+// poissonArrival models leeches arriving as a Poisson process with the given
+// rate (leeches per second).
+type poissonArrival struct {
+	rate float64
+	seed int64
+}
+
+func (a *poissonArrival) startOffset(leechIndex int) time.Duration {
+	// Every leech replays the same sequence of inter-arrival times and keeps
+	// the one matching its index.
+	r := rand.New(rand.NewSource(a.seed))
+	var t float64
+	for i := 0; i <= leechIndex; i++ {
+		t += r.ExpFloat64() / a.rate
+	}
+	return secondsToDuration(t)
+}
+
+// flashCrowdArrival makes every leech arrive at a uniformly random time within
+// a short window.
+type flashCrowdArrival struct {
+	window time.Duration
+	seed   int64
+}
+
+func (a *flashCrowdArrival) startOffset(leechIndex int) time.Duration {
+	if a.window <= 0 {
+		return 0
+	}
+	r := rand.New(rand.NewSource(a.seed + int64(leechIndex)))
+	return time.Duration(r.Int63n(int64(a.window)))
+}
+
+// diurnalArrival models a non-homogeneous Poisson process whose rate follows a
+// sine curve: rate * (1 + amplitude * sin(2*pi*t / period)).
+type diurnalArrival struct {
+	rate      float64
+	period    time.Duration
+	amplitude float64
+	seed      int64
+}
+
+func (a *diurnalArrival) startOffset(leechIndex int) time.Duration {
+	// Sample arrivals by thinning a homogeneous process running at the peak rate.
+	r := rand.New(rand.NewSource(a.seed))
+	peak := a.rate * (1 + a.amplitude)
+	period := a.period.Seconds()
+	var t float64
+	for arrived := 0; ; {
+		t += r.ExpFloat64() / peak
+		current := a.rate * (1 + a.amplitude*math.Sin(2*math.Pi*t/period))
+		if r.Float64()*peak <= current {
+			if arrived == leechIndex {
+				return secondsToDuration(t)
+			}
+			arrived++
+		}
+	}
+}
+
+// traceArrival replays start offsets read from a trace file.
+type traceArrival struct {
+	offsets []time.Duration
+}
+
+func (a *traceArrival) startOffset(leechIndex int) time.Duration {
+	return a.offsets[leechIndex]
+}
+
+// readArrivalTrace reads one start offset (in milliseconds) per line. Empty
+// lines and lines starting with # are ignored.
+func readArrivalTrace(path string) ([]time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var offsets []time.Duration
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ms, err := strconv.ParseFloat(line, 64)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("Invalid offset '%s' in arrival trace %s", line, path)
+		}
+		offsets = append(offsets, time.Duration(ms*float64(time.Millisecond)))
+	}
+	return offsets, scanner.Err()
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// emitBandwidth records the traffic of this node since the last run, per
+// protocol and with every other peer of the experiment, and resets the
+// bandwidth counters for the next run. IPFS nodes record their totals and
+// reset the counters themselves in EmitMetrics, so this must run before it.
+func (t *NodeTestData) emitBandwidth(recorder *metricsRecorder) {
+	if t.reporter == nil {
+		return
+	}
+
+	byPeer := t.reporter.GetBandwidthByPeer()
+	for _, peerInfo := range t.peerInfos {
+		if t.isSelf(peerInfo) {
+			continue
+		}
+		stats, ok := byPeer[peerInfo.Addr.ID]
+		if !ok {
+			continue
+		}
+		peerRecorder := recorder.with("peerType", peerInfo.Nodetp).with("peerTypeIndex", peerInfo.TpIndex)
+		peerRecorder.Record("peer_total_in", float64(stats.TotalIn))
+		peerRecorder.Record("peer_total_out", float64(stats.TotalOut))
+		peerRecorder.Record("peer_rate_in", stats.RateIn)
+		peerRecorder.Record("peer_rate_out", stats.RateOut)
+	}
+
+	for proto, stats := range t.reporter.GetBandwidthByProtocol() {
+		protoRecorder := recorder.with("protocol", proto)
+		protoRecorder.Record("protocol_total_in", float64(stats.TotalIn))
+		protoRecorder.Record("protocol_total_out", float64(stats.TotalOut))
+		protoRecorder.Record("protocol_rate_in", stats.RateIn)
+		protoRecorder.Record("protocol_rate_out", stats.RateOut)
+	}
+
+	if _, ok := t.node.(*utils.IPFSNode); ok {
+		return
+	}
+	totals := t.reporter.GetBandwidthTotals()
+	recorder.Record("total_in", float64(totals.TotalIn))
+	recorder.Record("total_out", float64(totals.TotalOut))
+	recorder.Record("rate_in", totals.RateIn)
+	recorder.Record("rate_out", totals.RateOut)
+	t.reporter.Reset()
+}
@@ -0,0 +1,258 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/testground/sdk-go/runtime"
+	tgsync "github.com/testground/sdk-go/sync"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils/dialer"
+)
+
+// behaviour is the way a peer takes part in the trade test case.
+type behaviour string
+
+const (
+	// Serves and downloads like any bitswap node.
+	honest behaviour = "honest"
+	// Downloads but never sends blocks or HAVEs.
+	freeRider behaviour = "free-rider"
+	// Sends blocks no faster than throttle_rate_kbps.
+	throttled behaviour = "throttled"
+	// Comes back under a fresh peer ID on every run after the first, dropping
+	// its ledgers.
+	whitewasher behaviour = "whitewasher"
+	// Answers HAVE for blocks it doesn't have.
+	liar behaviour = "liar"
+)
+
+// parseBehaviours reads a comma-separated list of <type index>=<behaviour>
+// entries, e.g. "1=free-rider,2=throttled".
+func parseBehaviours(value string) (map[int]behaviour, error) {
+	behaviours := make(map[int]behaviour)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.Split(entry, "=")
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid behaviour entry '%s'", entry)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid behaviour entry '%s'", entry)
+		}
+		b := behaviour(strings.TrimSpace(kv[1]))
+		switch b {
+		case honest, freeRider, throttled, whitewasher, liar:
+		default:
+			return nil, fmt.Errorf("Behaviour %s not implemented", b)
+		}
+		behaviours[index] = b
+	}
+	return behaviours, nil
+}
+
+// behaviourOf returns the behaviour of the peer with the given type index.
+// Peers are honest unless the behaviours param says otherwise.
+func (tv *TestVars) behaviourOf(tpindex int) behaviour {
+	if b, ok := tv.Behaviours[tpindex]; ok {
+		return b
+	}
+	return honest
+}
+
+// hooks returns the bitswap message hooks that implement the behaviour.
+func (b behaviour) hooks(tv *TestVars, clock utils.Clock) []utils.MessageHooks {
+	switch b {
+	case freeRider:
+		return []utils.MessageHooks{{Outgoing: freeRide}}
+	case throttled:
+		t := &uploadThrottle{clock: clock, rate: float64(tv.ThrottleRate)}
+		return []utils.MessageHooks{{Outgoing: t.outgoing}}
+	case liar:
+		return []utils.MessageHooks{{Outgoing: lie}}
+	}
+	return nil
+}
+
+// freeRide strips every block and HAVE from the messages a node sends, so it
+// keeps asking for content without ever serving any.
+func freeRide(_ context.Context, _ peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	out := utils.FilterMessage(msg,
+		func(blocks.Block) blocks.Block { return nil },
+		func(_ cid.Cid, have bool) (bool, bool) { return !have, have })
+	if out.Empty() {
+		return nil
+	}
+	return out
+}
+
+// lie turns every DONT_HAVE a node sends into a HAVE.
+func lie(_ context.Context, _ peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	return utils.FilterMessage(msg, nil, func(cid.Cid, bool) (bool, bool) { return true, true })
+}
+
+// uploadThrottle limits the rate at which a node sends blocks. The limit is
+// shared by all the peers the node sends to.
+type uploadThrottle struct {
+	clock utils.Clock
+	mu    sync.Mutex
+	rate  float64 // bytes per second
+	next  time.Time
+}
+
+func (t *uploadThrottle) outgoing(ctx context.Context, _ peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	var size int
+	for _, b := range msg.Blocks() {
+		size += len(b.RawData())
+	}
+	if size == 0 || t.rate <= 0 {
+		return msg
+	}
+
+	// Reserve the next slot in which the blocks can go out.
+	t.mu.Lock()
+	now := t.clock.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(secondsToDuration(float64(size) / t.rate))
+	wait := t.next.Sub(now)
+	t.mu.Unlock()
+
+	select {
+	case <-t.clock.After(wait):
+		return msg
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// whitewash replaces the host and bitswap node with fresh ones under a new
+// peer ID, so the other peers see a newcomer with empty ledgers. The
+// blockstore, and with it the published content, is kept.
+func (t *NodeTestData) whitewash(ctx context.Context, runenv *runtime.RunEnv, hooks []utils.MessageHooks) (*utils.BitswapNode, error) {
+	old, ok := t.node.(*utils.BitswapNode)
+	if !ok {
+		return nil, errors.New("Only bitswap nodes can whitewash")
+	}
+	if err := old.Close(); err != nil {
+		return nil, err
+	}
+	if err := (*t.host).Close(); err != nil {
+		return nil, err
+	}
+
+	privKey, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		return nil, err
+	}
+	h, reporter, err := t.newHost(ctx, privKey, t.nConfig.AddrInfo.Addrs)
+	if err != nil {
+		return nil, err
+	}
+	if t.tracer != nil {
+		hooks = append(hooks, t.tracer.Hooks())
+	}
+	bsnode, err := t.createBitswapNode(ctx, h, old.Blockstore(), hooks)
+	if err != nil {
+		return nil, err
+	}
+	runenv.RecordMessage("Whitewashed peer %s into %s", old.Host().ID(), h.ID())
+
+	t.node = bsnode
+	t.host = &h
+	t.reporter = reporter
+	t.timeline = bsnode.Timeline()
+	return bsnode, nil
+}
+
+// dialPartners connects to every given peer. Whitewashers use it instead of
+// the dialer, so they connect to their trade partners as the newcomer they
+// are rather than waiting to be dialed.
+func (t *NodeTestData) dialPartners(ctx context.Context, peers []utils.PeerInfo) ([]peer.AddrInfo, error) {
+	var dialed []peer.AddrInfo
+	for _, p := range peers {
+		if err := (*t.host).Connect(ctx, p.Addr); err != nil {
+			return nil, fmt.Errorf("Error while dialing peer %v: %w", p.Addr.Addrs, err)
+		}
+		dialed = append(dialed, p.Addr)
+	}
+	return dialed, nil
+}
+
+// whitewashedTopic is where the whitewashers publish the peer they came back
+// as in a run.
+func whitewashedTopic(runID string) *tgsync.Topic {
+	return tgsync.NewTopic("whitewashed-"+runID, &utils.PeerInfo{})
+}
+
+// updateWhitewashed publishes the new peer ID of this node if it whitewashed
+// in this run, and waits for the new peer IDs of all count whitewashers to
+// replace theirs in the peer infos, so ledgers and metrics are looked up
+// under the peer IDs they have now. It returns the peers that whitewashed.
+func (t *NodeTestData) updateWhitewashed(ctx context.Context, runID string, whitewashed bool, count int) (map[ledgerPeer]bool, error) {
+	topic := whitewashedTopic(runID)
+	if whitewashed {
+		info := &utils.PeerInfo{
+			Addr:    peer.AddrInfo{ID: (*t.host).ID(), Addrs: t.nConfig.AddrInfo.Addrs},
+			Nodetp:  t.nodetp,
+			TpIndex: t.tpindex,
+		}
+		if _, err := t.coord.Publish(ctx, topic, info); err != nil {
+			return nil, fmt.Errorf("Failed to publish whitewashed peer %w", err)
+		}
+	}
+
+	peerCh := make(chan *utils.PeerInfo)
+	sctx, cancelSub := context.WithCancel(ctx)
+	defer cancelSub()
+	if _, err := t.coord.Subscribe(sctx, topic, peerCh); err != nil {
+		return nil, fmt.Errorf("Failed to subscribe to whitewashed peers %w", err)
+	}
+	infos, err := dialer.PeerInfosFromChan(peerCh, count)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := make(map[ledgerPeer]bool, len(infos))
+	for _, info := range infos {
+		updated[ledgerPeer{info.Nodetp, info.TpIndex}] = true
+		for i, peerInfo := range t.peerInfos {
+			if peerInfo.Nodetp == info.Nodetp && peerInfo.TpIndex == info.TpIndex {
+				t.peerInfos[i] = info
+			}
+		}
+	}
+	t.namePeers()
+	return updated, nil
+}
+
+// newByzantine returns the byzantine behaviour of this node if it is one of
+// the first byzantine_seeds seeds, or nil.
+func newByzantine(runenv *runtime.RunEnv, baseT *TestData) (*utils.Byzantine, error) {
+	if !runenv.IsParamSet("byzantine") || runenv.StringParam("byzantine") == "none" {
+		return nil, nil
+	}
+	if baseT.nodetp != utils.Seed || baseT.tpindex >= runenv.IntParam("byzantine_seeds") {
+		return nil, nil
+	}
+	mode := utils.ByzantineMode(runenv.StringParam("byzantine"))
+	rate := float64(runenv.IntParam("byzantine_rate_pct")) / 100
+	runenv.RecordMessage("Byzantine seed, %s blocks at a %.2f rate", mode, rate)
+	return utils.NewByzantine(mode, rate, int64(baseT.tpindex))
+}
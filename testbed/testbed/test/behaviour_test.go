@@ -0,0 +1,93 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+type metricsMap map[string]float64
+
+func (m metricsMap) Record(key string, value float64) {
+	m[key] = value
+}
+
+// TestFreeRider checks that a free-rider fetches from an honest peer, never
+// serves it, and isn't credited with the blocks it didn't send.
+func TestFreeRider(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	newNode := func(hooks ...utils.MessageHooks) *utils.BitswapNode {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dstore, err := utils.CreateDatastore(false, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bstore, err := utils.CreateBlockstore(ctx, dstore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := utils.CreateBitswapNode(ctx, h, bstore, hooks...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		return n
+	}
+	rider := newNode(freeRider.hooks(&TestVars{}, utils.RealClock)...)
+	honest := newNode()
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	add := func(n *utils.BitswapNode, seed int64) cid.Cid {
+		f, err := utils.NewRandFile(1024*1024, seed).GenerateFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := n.Add(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	riderFile, honestFile := add(rider, 1), add(honest, 2)
+
+	if _, err := rider.Fetch(ctx, honestFile, nil); err != nil {
+		t.Fatalf("Free-rider couldn't fetch from the honest peer: %s", err)
+	}
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelFetch()
+	if _, err := honest.Fetch(fetchCtx, riderFile, nil); err == nil {
+		t.Fatal("Honest peer fetched from the free-rider")
+	}
+
+	metrics := metricsMap{}
+	if err := rider.EmitMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics["data_sent"] != 0 || metrics["blks_sent"] != 0 {
+		t.Errorf("Free-rider sent %v bytes in %v blocks, want none", metrics["data_sent"], metrics["blks_sent"])
+	}
+	if metrics["data_rcvd"] <= 0 {
+		t.Errorf("Free-rider received %v bytes", metrics["data_rcvd"])
+	}
+	if sent := rider.LedgerForPeer(honest.Host().ID()).Sent; sent != 0 {
+		t.Errorf("Ledger of the free-rider has %d bytes sent, want 0", sent)
+	}
+	if recv := honest.LedgerForPeer(rider.Host().ID()).Recv; recv != 0 {
+		t.Errorf("Ledger of the honest peer has %d bytes received from the free-rider, want 0", recv)
+	}
+}
@@ -0,0 +1,387 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/testground/sdk-go/run"
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// Catalog serves a catalog of items from the seeds. Each leech requests a
+// sequence of items drawn from a popularity distribution, and passive nodes
+// act as caches holding the most popular items.
+func Catalog(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
+	// Test Parameters
+	testvars, err := getEnvVars(runenv)
+	if err != nil {
+		return err
+	}
+	ctlg, err := newCatalog(runenv, testvars)
+	if err != nil {
+		return err
+	}
+	nodeType := runenv.StringParam("node_type")
+
+	/// --- Set up
+	ctx, cancel := context.WithTimeout(context.Background(), testvars.Timeout)
+	defer cancel()
+	baseT, err := InitializeTest(ctx, runenv, testvars, NewTestgroundCoordinator(ctx, runenv))
+	if err != nil {
+		return err
+	}
+	nodeInitializer, ok := supportedNodes[nodeType]
+	if !ok {
+		return fmt.Errorf("unsupported node type: %s", nodeType)
+	}
+	t, err := nodeInitializer(ctx, runenv, testvars, baseT)
+	if err != nil {
+		return err
+	}
+	signalAndWaitForAll := t.signalAndWaitForAll
+
+	// Start still alive process if enabled
+	t.stillAlive(runenv, testvars)
+
+	// The catalog defines the content, so only the network settings of the
+	// first permutation are used.
+	testParams := testvars.Permutations[0]
+
+	// Set up network (with traffic shaping)
+	if err := t.setupNetwork(ctx, runenv, testParams); err != nil {
+		return fmt.Errorf("Failed to set up network: %v", err)
+	}
+
+	// Wait for all nodes to be ready to publish the catalog
+	err = signalAndWaitForAll("start-catalog-publish")
+	if err != nil {
+		return err
+	}
+
+	rootCids := make([]cid.Cid, len(ctlg.items))
+	for i, item := range ctlg.items {
+		switch t.nodetp {
+		case utils.Seed:
+			rootCids[i], err = t.addPublishFile(ctx, i, item, runenv, testvars)
+		default:
+			rootCids[i], err = t.readFile(ctx, i, runenv, testvars)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	runenv.RecordMessage("Catalog of %d items injest complete...", len(rootCids))
+	// Wait for all nodes to be ready to dial
+	err = signalAndWaitForAll("injest-complete")
+	if err != nil {
+		return err
+	}
+
+	for runNum := 1; runNum < testvars.RunCount+1; runNum++ {
+		// Reset the timeout for each run
+		ctx, cancel := context.WithTimeout(ctx, testvars.RunTimeout)
+		defer cancel()
+
+		runID := fmt.Sprintf("%d", runNum)
+
+		// Wait for all nodes to be ready to start the run
+		err = signalAndWaitForAll("start-run-" + runID)
+		if err != nil {
+			return err
+		}
+
+		dialed, err := t.dialFn(ctx, t.node.Host(), t.nodetp, t.peerInfos, testvars.MaxConnectionRate)
+		if err != nil {
+			return err
+		}
+		runenv.RecordMessage("Dialed %d other nodes", len(dialed))
+
+		// Wait for all nodes to be connected
+		err = signalAndWaitForAll("connect-complete-" + runID)
+		if err != nil {
+			return err
+		}
+
+		// Passive nodes fill their cache with the most popular items before
+		// leeches start requesting.
+		var cached []cid.Cid
+		if t.nodetp == utils.Passive {
+			cached = rootCids[:ctlg.cacheSize]
+			if err := t.warmCache(ctx, runenv, testvars, cached); err != nil {
+				return err
+			}
+		}
+
+		err = signalAndWaitForAll("cache-warm-" + runID)
+		if err != nil {
+			return err
+		}
+
+		/// --- Start test
+
+		// Measure the resources the node uses while exchanging data.
+		resources, err := utils.NewResourceMeter()
+		if err != nil {
+			return err
+		}
+
+		recorder := newMetricsRecorder(runenv, runNum, t.seq, t.grpseq, nodeType, testParams.Latency,
+			testParams.Bandwidth, int(ctlg.size()), t.nodetp, t.tpindex, testvars.MaxConnectionRate)
+
+		if t.nodetp == utils.Leech {
+			stats, err := t.requestCatalog(ctx, runenv, testvars, ctlg, rootCids, recorder)
+			if err != nil {
+				return err
+			}
+			stats.emit(recorder)
+		}
+
+		// Wait for all leeches to be done with their requests
+		err = signalAndWaitForAll("catalog-complete-" + runID)
+		if err != nil {
+			return err
+		}
+
+		/// --- Report stats
+		t.emitBandwidth(recorder)
+		if err := t.emitResources(resources, recorder); err != nil {
+			return err
+		}
+		if err := t.node.EmitMetrics(recorder); err != nil {
+			return err
+		}
+		runenv.RecordMessage("Finishing emitting metrics. Starting to clean...")
+
+		if err := t.disconnect(runenv); err != nil {
+			return err
+		}
+		for _, c := range cached {
+			if err := t.node.ClearDatastore(ctx, c); err != nil {
+				return fmt.Errorf("Error clearing datastore: %w", err)
+			}
+		}
+	}
+
+	for _, c := range rootCids {
+		if !c.Defined() {
+			continue
+		}
+		if err := t.cleanupFile(ctx, c); err != nil {
+			return err
+		}
+	}
+	err = t.close()
+	if err != nil {
+		return err
+	}
+
+	runenv.RecordMessage("Ending testcase")
+	return nil
+}
+
+// catalog describes the items seeds publish and how leeches request them.
+type catalog struct {
+	items      []utils.TestFile
+	popularity string
+	exponent   float64
+	requests   int
+	cacheSize  int
+	seed       int64
+}
+
+func newCatalog(runenv *runtime.RunEnv, testvars *TestVars) (*catalog, error) {
+	fileSizes, err := utils.ParseIntArray(runenv.StringParam("file_size"))
+	if err != nil {
+		return nil, err
+	}
+	size := runenv.IntParam("catalog_size")
+	if size < 1 {
+		return nil, fmt.Errorf("catalog_size must be at least 1")
+	}
+
+	c := &catalog{
+		popularity: runenv.StringParam("catalog_popularity"),
+		requests:   runenv.IntParam("catalog_requests"),
+		seed:       int64(runenv.IntParam("catalog_seed")),
+	}
+	// Item sizes cycle over the given file sizes.
+	for i := 0; i < size; i++ {
+		c.items = append(c.items, utils.NewRandFile(int64(fileSizes[i%len(fileSizes)]), int64(i)))
+	}
+
+	switch c.popularity {
+	case "zipf":
+		c.exponent, err = strconv.ParseFloat(runenv.StringParam("zipf_exponent"), 64)
+		if err != nil || c.exponent <= 1 {
+			return nil, fmt.Errorf("Invalid zipf exponent %s, it must be greater than 1", runenv.StringParam("zipf_exponent"))
+		}
+	case "uniform":
+	default:
+		return nil, fmt.Errorf("Catalog popularity %s not implemented", c.popularity)
+	}
+
+	// Without passive nodes there is nobody to cache items.
+	if testvars.PassiveCount > 0 {
+		c.cacheSize = runenv.IntParam("catalog_cache_size")
+		if c.cacheSize > size {
+			c.cacheSize = size
+		}
+	}
+	return c, nil
+}
+
+// sequence returns the items requested by a leech, where lower item indexes
+// are more popular.
+func (c *catalog) sequence(leechIndex int) []int {
+	r := rand.New(rand.NewSource(c.seed + int64(leechIndex)))
+	seq := make([]int, c.requests)
+	switch c.popularity {
+	case "zipf":
+		z := rand.NewZipf(r, c.exponent, 1, uint64(len(c.items)-1))
+		for i := range seq {
+			seq[i] = int(z.Uint64())
+		}
+	default:
+		for i := range seq {
+			seq[i] = r.Intn(len(c.items))
+		}
+	}
+	return seq
+}
+
+func (c *catalog) size() int64 {
+	var size int64
+	for _, item := range c.items {
+		size += item.Size()
+	}
+	return size
+}
+
+type catalogStats struct {
+	requests int
+	fails    int
+	bytes    int64
+	elapsed  time.Duration
+	// Blocks received, and how many of them came from passive nodes, for
+	// nodes that follow where their blocks come from.
+	blocks       int
+	cachedBlocks int
+}
+
+func (s *catalogStats) emit(recorder utils.MetricsRecorder) {
+	recorder.Record("catalog_requests", float64(s.requests))
+	recorder.Record("catalog_fails", float64(s.fails))
+	recorder.Record("bytes_fetched", float64(s.bytes))
+	if s.blocks > 0 {
+		recorder.Record("cache_hit_ratio", float64(s.cachedBlocks)/float64(s.blocks))
+	}
+	if s.elapsed > 0 {
+		recorder.Record("throughput", float64(s.bytes)/s.elapsed.Seconds())
+	}
+}
+
+// requestCatalog fetches the leech's sequence of items one after the other,
+// recording the latency of every request. Items are dropped from the local
+// store after each request so repeated requests hit the network again. The
+// cache hit ratio is the fraction of the blocks received that passive nodes
+// sent, as followed by the block timeline of the node.
+func (t *NodeTestData) requestCatalog(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars,
+	ctlg *catalog, rootCids []cid.Cid, recorder *metricsRecorder) (*catalogStats, error) {
+	stats := &catalogStats{}
+	start := time.Now()
+	for reqNum, item := range ctlg.sequence(t.tpindex) {
+		stats.requests++
+
+		runenv.RecordMessage("Request %d: fetching catalog item %d", reqNum, item)
+		reqStart := time.Now()
+		if t.timeline != nil {
+			t.timeline.Start(rootCids[item])
+		}
+		ctxFetch, cancel := context.WithTimeout(ctx, testvars.RunTimeout/2)
+		rcvFile, err := t.node.Fetch(ctxFetch, rootCids[item], t.peerInfos)
+		if err != nil {
+			cancel()
+			if t.timeline != nil {
+				t.timeline.Stop()
+			}
+			runenv.RecordMessage("Error fetching catalog item %d: %v", item, err)
+			stats.fails++
+			continue
+		}
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("catalog-%d-%d", t.tpindex, reqNum))
+		err = files.WriteTo(rcvFile, path)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		latency := time.Since(reqStart)
+		s, _ := rcvFile.Size()
+		stats.bytes += s
+		if t.timeline != nil {
+			t.timeline.Stop()
+			t.countCachedBlocks(stats, t.timeline.Arrivals())
+		}
+		recorder.with("item", item).with("request", reqNum).Record("request_latency", float64(latency))
+
+		if err := os.RemoveAll(path); err != nil {
+			return nil, err
+		}
+		if err := t.node.ClearDatastore(ctx, rootCids[item]); err != nil {
+			return nil, fmt.Errorf("Error clearing datastore: %w", err)
+		}
+	}
+	stats.elapsed = time.Since(start)
+	return stats, nil
+}
+
+// countCachedBlocks adds the blocks of a request, and the ones passive nodes
+// sent, to the stats.
+func (t *NodeTestData) countCachedBlocks(stats *catalogStats, arrivals []utils.BlockArrival) {
+	passive := make(map[peer.ID]bool)
+	for _, peerInfo := range t.peerInfos {
+		if peerInfo.Nodetp == utils.Passive {
+			passive[peerInfo.Addr.ID] = true
+		}
+	}
+	for _, a := range arrivals {
+		stats.blocks++
+		if passive[a.Peer] {
+			stats.cachedBlocks++
+		}
+	}
+}
+
+// warmCache fetches the given items so they can be served to leeches.
+func (t *NodeTestData) warmCache(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars, rootCids []cid.Cid) error {
+	for _, c := range rootCids {
+		ctxFetch, cancel := context.WithTimeout(ctx, testvars.RunTimeout/2)
+		rcvFile, err := t.node.Fetch(ctxFetch, c, t.peerInfos)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("Error caching %s: %w", c, err)
+		}
+		// Walk the whole file so lazy fetchers pull every block.
+		path := filepath.Join(os.TempDir(), "cache-"+c.String())
+		err = files.WriteTo(rcvFile, path)
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	runenv.RecordMessage("Cached %d catalog items", len(rootCids))
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -16,12 +17,12 @@ import (
 	"github.com/testground/sdk-go/sync"
 
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/metrics"
 	"github.com/libp2p/go-libp2p-core/peer"
 
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
 	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
 	"github.com/protocol/beyond-bitswap/testbed/testbed/utils/dialer"
-
-	"github.com/testground/sdk-go/network"
 )
 
 type TestPermutation struct {
@@ -48,13 +49,18 @@ type TestVars struct {
 	LlEnabled         bool
 	Dialer            string
 	NumWaves          int
+	ArrivalModel      string
+	Arrival           arrivalModel
+	Behaviours        map[int]behaviour
+	ThrottleRate      int
 	Permutations      []TestPermutation
 	DiskStore         bool
+	BitswapTrace      bool
+	RecordWorkload    bool
 }
 
 type TestData struct {
-	client              *sync.DefaultClient
-	nwClient            *network.Client
+	coord               Coordinator
 	nConfig             *utils.NodeConfig
 	peerInfos           []utils.PeerInfo
 	dialFn              dialer.Dialer
@@ -64,6 +70,9 @@ type TestData struct {
 	nodetp              utils.NodeType
 	tpindex             int
 	seedIndex           int64
+	// peerNames is a snapshot of the names of the peers in peerInfos, for
+	// the goroutines that name peers while peerInfos may change.
+	peerNames atomic.Value
 }
 
 func getEnvVars(runenv *runtime.RunEnv) (*TestVars, error) {
@@ -110,12 +119,38 @@ func getEnvVars(runenv *runtime.RunEnv) (*TestVars, error) {
 	if runenv.IsParamSet("number_waves") {
 		tv.NumWaves = runenv.IntParam("number_waves")
 	}
+	if runenv.IsParamSet("arrival_model") {
+		tv.ArrivalModel = runenv.StringParam("arrival_model")
+	}
 	if runenv.IsParamSet("enable_providing") {
 		tv.ProvidingEnabled = runenv.BooleanParam("enable_providing")
 	}
 	if runenv.IsParamSet("disk_store") {
 		tv.DiskStore = runenv.BooleanParam("disk_store")
 	}
+	if runenv.IsParamSet("bitswap_trace") {
+		tv.BitswapTrace = runenv.BooleanParam("bitswap_trace")
+	}
+	if runenv.IsParamSet("record_workload") {
+		tv.RecordWorkload = runenv.BooleanParam("record_workload")
+	}
+
+	if runenv.IsParamSet("behaviours") {
+		behaviours, err := parseBehaviours(runenv.StringParam("behaviours"))
+		if err != nil {
+			return nil, err
+		}
+		tv.Behaviours = behaviours
+	}
+	if runenv.IsParamSet("throttle_rate_kbps") {
+		tv.ThrottleRate = runenv.IntParam("throttle_rate_kbps") * 1024
+	}
+
+	arrival, err := newArrivalModel(runenv, tv)
+	if err != nil {
+		return nil, err
+	}
+	tv.Arrival = arrival
 
 	bandwidths, err := utils.ParseIntArray(runenv.StringParam("bandwidth_mb"))
 	if err != nil {
@@ -149,11 +184,8 @@ func getEnvVars(runenv *runtime.RunEnv) (*TestVars, error) {
 	return tv, nil
 }
 
-func InitializeTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars) (*TestData, error) {
-	client := sync.MustBoundClient(ctx, runenv)
-	nwClient := network.NewClient(client, runenv)
-
-	nConfig, err := utils.GenerateAddrInfo(nwClient.MustGetDataNetworkIP().String())
+func InitializeTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars, coord Coordinator) (*TestData, error) {
+	nConfig, err := utils.GenerateAddrInfo(coord.DataIP())
 	if err != nil {
 		runenv.RecordMessage("Error generating node config")
 		return nil, err
@@ -162,19 +194,19 @@ func InitializeTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestV
 	peers := sync.NewTopic("peers", &peer.AddrInfo{})
 
 	// Get sequence number of this host
-	seq, err := client.Publish(ctx, peers, *nConfig.AddrInfo)
+	seq, err := coord.Publish(ctx, peers, *nConfig.AddrInfo)
 	if err != nil {
 		return nil, err
 	}
 	// Type of node and identifiers assigned.
-	grpseq, nodetp, tpindex, err := parseType(ctx, runenv, client, nConfig.AddrInfo, seq)
+	grpseq, nodetp, tpindex, err := parseType(ctx, runenv, coord, nConfig.AddrInfo, seq)
 	if err != nil {
 		return nil, err
 	}
 
 	peerInfos := sync.NewTopic("peerInfos", &utils.PeerInfo{})
 	// Publish peer info for dialing
-	_, err = client.Publish(ctx, peerInfos, &utils.PeerInfo{Addr: *nConfig.AddrInfo, Nodetp: nodetp, TpIndex: tpindex})
+	_, err = coord.Publish(ctx, peerInfos, &utils.PeerInfo{Addr: *nConfig.AddrInfo, Nodetp: nodetp, TpIndex: tpindex})
 	if err != nil {
 		return nil, err
 	}
@@ -194,7 +226,7 @@ func InitializeTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestV
 		} else {
 			// If we are in group mode, signal other seed nodes to work out the
 			// seed index
-			seedSeq, err := getNodeSetSeq(ctx, client, nConfig.AddrInfo, "seeds")
+			seedSeq, err := getNodeSetSeq(ctx, coord, nConfig.AddrInfo, "seeds")
 			if err != nil {
 				return nil, err
 			}
@@ -207,7 +239,7 @@ func InitializeTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestV
 	// Get addresses of all peers
 	peerCh := make(chan *utils.PeerInfo)
 	sctx, cancelSub := context.WithCancel(ctx)
-	if _, err := client.Subscribe(sctx, peerInfos, peerCh); err != nil {
+	if _, err := coord.Subscribe(sctx, peerInfos, peerCh); err != nil {
 		cancelSub()
 		return nil, err
 	}
@@ -224,15 +256,21 @@ func InitializeTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestV
 	// Signal that this node is in the given state, and wait for all peers to
 	// send the same signal
 	signalAndWaitForAll := func(state string) error {
-		_, err := client.SignalAndWait(ctx, sync.State(state), runenv.TestInstanceCount)
+		_, err := coord.SignalAndWait(ctx, sync.State(state), runenv.TestInstanceCount)
 		return err
 	}
 
-	return &TestData{
-		client, nwClient,
-		nConfig, infos, dialFn, signalAndWaitForAll,
-		seq, grpseq, nodetp, tpindex, seedIndex,
-	}, nil
+	t := &TestData{
+		coord, nConfig, infos, dialFn, signalAndWaitForAll,
+		seq, grpseq, nodetp, tpindex, seedIndex, atomic.Value{},
+	}
+	t.namePeers()
+	return t, nil
+}
+
+// setupNetwork shapes the traffic of this node for a permutation.
+func (t *TestData) setupNetwork(ctx context.Context, runenv *runtime.RunEnv, p TestPermutation) error {
+	return t.coord.ConfigureNetwork(ctx, runenv, t.nodetp, t.tpindex, p.Latency, p.Bandwidth, p.JitterPct)
 }
 
 func (t *TestData) publishFile(ctx context.Context, fIndex int, cid *cid.Cid, runenv *runtime.RunEnv) error {
@@ -241,7 +279,7 @@ func (t *TestData) publishFile(ctx context.Context, fIndex int, cid *cid.Cid, ru
 
 	runenv.RecordMessage("Published Added CID: %v", *cid)
 	// Inform other nodes of the root CID
-	if _, err := t.client.Publish(ctx, rootCidTopic, cid); err != nil {
+	if _, err := t.coord.Publish(ctx, rootCidTopic, cid); err != nil {
 		return fmt.Errorf("Failed to get Redis Sync rootCidTopic %w", err)
 	}
 	return nil
@@ -254,7 +292,7 @@ func (t *TestData) readFile(ctx context.Context, fIndex int, runenv *runtime.Run
 	rootCidCh := make(chan *cid.Cid, 1)
 	sctx, cancelRootCidSub := context.WithCancel(ctx)
 	defer cancelRootCidSub()
-	if _, err := t.client.Subscribe(sctx, rootCidTopic, rootCidCh); err != nil {
+	if _, err := t.coord.Subscribe(sctx, rootCidTopic, rootCidCh); err != nil {
 		return cid.Undef, fmt.Errorf("Failed to subscribe to rootCidTopic %w", err)
 	}
 	// Note: only need to get the root CID from one seed - it should be the
@@ -275,13 +313,13 @@ func (t *TestData) runTCPServer(ctx context.Context, fIndex int, runNum int, f u
 	runenv.RecordMessage("Starting TCP server in seed")
 
 	// Start TCP server for file
-	tcpServer, err := utils.SpawnTCPServer(ctx, t.nwClient.MustGetDataNetworkIP().String(), f)
+	tcpServer, err := utils.SpawnTCPServer(ctx, t.coord.DataIP(), f)
 	if err != nil {
 		return fmt.Errorf("Failed to start tcpServer in seed %w", err)
 	}
 	// Inform other nodes of the TCPServerAddr
 	runenv.RecordMessage("Publishing TCP address %v", tcpServer.Addr)
-	if _, err = t.client.Publish(ctx, tcpAddrTopic, tcpServer.Addr); err != nil {
+	if _, err = t.coord.Publish(ctx, tcpAddrTopic, tcpServer.Addr); err != nil {
 		return fmt.Errorf("Failed to get Redis Sync tcpAddr %w", err)
 	}
 	runenv.RecordMessage("Waiting to end finish TCP fetch")
@@ -302,7 +340,7 @@ func (t *TestData) runTCPFetch(ctx context.Context, fIndex int, runNum int, rune
 	// TCP variables
 	tcpAddrTopic := getTCPAddrTopic(fIndex, runNum)
 	tcpAddrCh := make(chan *string, 1)
-	if _, err := t.client.Subscribe(ctx, tcpAddrTopic, tcpAddrCh); err != nil {
+	if _, err := t.coord.Subscribe(ctx, tcpAddrTopic, tcpAddrCh); err != nil {
 		return 0, fmt.Errorf("Failed to subscribe to tcpServerTopic %w", err)
 	}
 	tcpAddrPtr, ok := <-tcpAddrCh
@@ -331,19 +369,22 @@ func (t *TestData) runTCPFetch(ctx context.Context, fIndex int, runNum int, rune
 
 type NodeTestData struct {
 	*TestData
-	node utils.Node
-	host *host.Host
+	node     utils.Node
+	host     *host.Host
+	reporter *metrics.BandwidthCounter
+	timeline *utils.Timeline
+	tracer   *utils.MessageTracer
 }
 
 func (t *NodeTestData) stillAlive(runenv *runtime.RunEnv, v *TestVars) {
 	// starting liveness process for long-lasting experiments.
 	if v.LlEnabled {
-		go func(n utils.Node, runenv *runtime.RunEnv) {
+		go func(n utils.Node, runenv *runtime.RunEnv, clock utils.Clock) {
 			for {
 				n.EmitKeepAlive(runenv)
-				time.Sleep(15 * time.Second)
+				clock.Sleep(15 * time.Second)
 			}
-		}(t.node, runenv)
+		}(t.node, runenv, t.coord.Clock())
 	}
 }
 
@@ -369,7 +410,7 @@ func (t *NodeTestData) addPublishFile(ctx context.Context, fIndex int, f utils.T
 	return cid.Undef, nil
 }
 
-func (t *NodeTestData) cleanupRun(ctx context.Context, rootCid cid.Cid, runenv *runtime.RunEnv) error {
+func (t *NodeTestData) disconnect(runenv *runtime.RunEnv) error {
 	// Disconnect peers
 	for _, c := range t.node.Host().Network().Conns() {
 		err := c.Close()
@@ -378,6 +419,13 @@ func (t *NodeTestData) cleanupRun(ctx context.Context, rootCid cid.Cid, runenv *
 		}
 	}
 	runenv.RecordMessage("Closed Connections")
+	return nil
+}
+
+func (t *NodeTestData) cleanupRun(ctx context.Context, rootCid cid.Cid, runenv *runtime.RunEnv) error {
+	if err := t.disconnect(runenv); err != nil {
+		return err
+	}
 
 	if t.nodetp == utils.Leech || t.nodetp == utils.Passive {
 		// Clearing datastore
@@ -403,6 +451,11 @@ func (t *NodeTestData) cleanupFile(ctx context.Context, rootCid cid.Cid) error {
 }
 
 func (t *NodeTestData) close() error {
+	if t.tracer != nil {
+		if err := t.tracer.Close(); err != nil {
+			return fmt.Errorf("Error writing bitswap trace: %w", err)
+		}
+	}
 	if t.host == nil {
 		return nil
 	}
@@ -417,29 +470,51 @@ func (t *NodeTestData) emitMetrics(runenv *runtime.RunEnv, runNum int, transport
 		recorder.Record("time_to_fetch", float64(timeToFetch))
 		recorder.Record("leech_fails", float64(leechFails))
 		recorder.Record("tcp_fetch", float64(tcpFetch))
+		if t.timeline != nil {
+			t.timeline.EmitMetrics(recorder)
+		}
 	}
 
+	t.emitBandwidth(recorder)
 	return t.node.EmitMetrics(recorder)
 }
 
+// emitResources records the resources the process used since the meter
+// started. Instances that share their process all report its totals, so
+// their resource metrics are tagged scope:process.
+func (t *TestData) emitResources(resources *utils.ResourceMeter, recorder *metricsRecorder) error {
+	if _, ok := t.coord.(sharedProcess); ok {
+		recorder = recorder.with("scope", "process")
+	}
+	return resources.EmitMetrics(recorder)
+}
+
 type fetchResult struct {
 	CID  cid.Cid
+	From int
 	Time time.Duration
 }
 
 // @dgrisham
 func (t *NodeTestData) emitMetricsTrade(runenv *runtime.RunEnv, runNum int, transport string,
 	permutation TestPermutation, fetchResults []fetchResult, tcpFetch int64, fetchFails int64,
-	maxConnectionRate int) error {
+	maxConnectionRate int, b behaviour) error {
+	recorder := newMetricsRecorder(runenv, runNum, t.seq, t.grpseq, transport, permutation.Latency, permutation.Bandwidth, int(permutation.File.Size()), t.nodetp, t.tpindex, maxConnectionRate).
+		with("behaviour", b)
 	// emit download time for each fetched Cid
-	for fetchIdx, fetchResult := range fetchResults {
-		recorder := newMetricsRecorderTrade(runenv, runNum, t.seq, t.grpseq, transport, permutation.Latency, permutation.Bandwidth, int(permutation.File.Size()), t.nodetp, t.tpindex, maxConnectionRate, fetchResult.CID.String())
-		recorder.Record("fetch_time", float64(fetchResult.Time))
-		if err := t.node.EmitMetrics(recorder); err != nil {
-			return fmt.Errorf("Error emitting metrics for fetch idx %d: %s", fetchIdx, err.Error())
+	for _, fetchResult := range fetchResults {
+		if !fetchResult.CID.Defined() { // failed fetch
+			continue
 		}
+		recorder.with("fetchCid", fetchResult.CID).with("fetchFrom", fetchResult.From).Record("fetch_time", float64(fetchResult.Time))
 	}
 
+	// The node counters cover all the fetches of the run, and some of them
+	// start over once emitted, so they are emitted once per run.
+	t.emitBandwidth(recorder)
+	if err := t.node.EmitMetrics(recorder); err != nil {
+		return fmt.Errorf("Error emitting node metrics: %w", err)
+	}
 	return nil
 }
 
@@ -463,7 +538,7 @@ func generateAndAdd(ctx context.Context, runenv *runtime.RunEnv, node utils.Node
 	return &cid, err
 }
 
-func parseType(ctx context.Context, runenv *runtime.RunEnv, client *sync.DefaultClient, addrInfo *peer.AddrInfo, seq int64) (int64, utils.NodeType, int, error) {
+func parseType(ctx context.Context, runenv *runtime.RunEnv, coord Coordinator, addrInfo *peer.AddrInfo, seq int64) (int64, utils.NodeType, int, error) {
 	leechCount := runenv.IntParam("leech_count")
 	passiveCount := runenv.IntParam("passive_count")
 
@@ -490,7 +565,7 @@ func parseType(ctx context.Context, runenv *runtime.RunEnv, client *sync.Default
 		grpPrefix = runenv.TestGroupID + " "
 
 		var err error
-		grpseq, err = getNodeSetSeq(ctx, client, addrInfo, runenv.TestGroupID)
+		grpseq, err = getNodeSetSeq(ctx, coord, addrInfo, runenv.TestGroupID)
 		if err != nil {
 			return grpseq, nodetp, tpindex, err
 		}
@@ -516,10 +591,10 @@ func parseType(ctx context.Context, runenv *runtime.RunEnv, client *sync.Default
 	return grpseq, nodetp, tpindex, nil
 }
 
-func getNodeSetSeq(ctx context.Context, client *sync.DefaultClient, addrInfo *peer.AddrInfo, setID string) (int64, error) {
+func getNodeSetSeq(ctx context.Context, coord Coordinator, addrInfo *peer.AddrInfo, setID string) (int64, error) {
 	topic := sync.NewTopic("nodes"+setID, &peer.AddrInfo{})
 
-	return client.Publish(ctx, topic, addrInfo)
+	return coord.Publish(ctx, topic, addrInfo)
 }
 
 func fractionalDAG(ctx context.Context, runenv *runtime.RunEnv, seedIndex int, c cid.Cid, dserv ipld.DAGService) error {
@@ -597,40 +672,39 @@ func getTCPAddrTopic(id int, run int) *sync.Topic {
 
 type metricsRecorder struct {
 	runenv *runtime.RunEnv
-	id     string
+	dims   results.Dimensions
 }
 
 func newMetricsRecorder(runenv *runtime.RunEnv, runNum int, seq int64, grpseq int64,
 	transport string, latency time.Duration, bandwidthMB int, fileSize int, nodetp utils.NodeType, tpindex int,
-	maxConnectionRate int) utils.MetricsRecorder {
-	latencyMS := latency.Milliseconds()
+	maxConnectionRate int) *metricsRecorder {
 	instance := runenv.TestInstanceCount
 	leechCount := runenv.IntParam("leech_count")
 	passiveCount := runenv.IntParam("passive_count")
 
-	id := fmt.Sprintf("topology:(%d-%d-%d)/transport:%s/maxConnectionRate:%d/latencyMS:%d/bandwidthMB:%d/run:%d/seq:%d/groupName:%s/groupSeq:%d/fileSize:%d/nodeType:%s/nodeTypeIndex:%d",
-		instance-leechCount-passiveCount, leechCount, passiveCount, transport, maxConnectionRate,
-		latencyMS, bandwidthMB, runNum, seq, runenv.TestGroupID, grpseq, fileSize, nodetp, tpindex)
-
-	return &metricsRecorder{runenv, id}
+	return &metricsRecorder{runenv, results.Dimensions{
+		Seeds:             instance - leechCount - passiveCount,
+		Leeches:           leechCount,
+		Passives:          passiveCount,
+		Transport:         transport,
+		MaxConnectionRate: maxConnectionRate,
+		LatencyMS:         latency.Milliseconds(),
+		BandwidthMB:       bandwidthMB,
+		Run:               runNum,
+		Seq:               seq,
+		GroupName:         runenv.TestGroupID,
+		GroupSeq:          grpseq,
+		FileSize:          fileSize,
+		NodeType:          nodetp.String(),
+		NodeTypeIndex:     tpindex,
+	}}
 }
 
 func (mr *metricsRecorder) Record(key string, value float64) {
-	mr.runenv.R().RecordPoint(fmt.Sprintf("%s/name:%s", mr.id, key), value)
+	mr.runenv.R().RecordPoint(mr.dims.ID(key), value)
 }
 
-// @dgrisham
-func newMetricsRecorderTrade(runenv *runtime.RunEnv, runNum int, seq int64, grpseq int64,
-	transport string, latency time.Duration, bandwidthMB int, fileSize int, nodetp utils.NodeType, tpindex int,
-	maxConnectionRate int, fetchCid string) utils.MetricsRecorder {
-	latencyMS := latency.Milliseconds()
-	instance := runenv.TestInstanceCount
-	leechCount := runenv.IntParam("leech_count")
-	passiveCount := runenv.IntParam("passive_count")
-
-	id := fmt.Sprintf("topology:(%d-%d-%d)/transport:%s/maxConnectionRate:%d/latencyMS:%d/bandwidthMB:%d/run:%d/seq:%d/groupName:%s/groupSeq:%d/fileSize:%d/nodeType:%s/nodeTypeIndex:%d/fetchCid:%s",
-		instance-leechCount-passiveCount, leechCount, passiveCount, transport, maxConnectionRate,
-		latencyMS, bandwidthMB, runNum, seq, runenv.TestGroupID, grpseq, fileSize, nodetp, tpindex, fetchCid)
-
-	return &metricsRecorder{runenv, id}
+// with returns a recorder that tags every metric with an additional dimension.
+func (mr *metricsRecorder) with(key string, value interface{}) *metricsRecorder {
+	return &metricsRecorder{mr.runenv, mr.dims.With(key, value)}
 }
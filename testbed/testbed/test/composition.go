@@ -0,0 +1,92 @@
+package test
+
+import (
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+)
+
+// manifest holds the parameters of the test cases of the plan's
+// manifest.toml.
+type manifest struct {
+	TestCases []struct {
+		Name   string `toml:"name"`
+		Params map[string]struct {
+			Default interface{} `toml:"default"`
+		} `toml:"params"`
+	} `toml:"testcases"`
+}
+
+// readManifestDefaults returns the default value of every parameter of a test
+// case, as testground passes them to the instances.
+func readManifestDefaults(path string, testCase string) (map[string]string, error) {
+	var m manifest
+	if _, err := toml.DecodeFile(path, &m); err != nil {
+		return nil, fmt.Errorf("Error reading manifest %s: %w", path, err)
+	}
+	for _, tc := range m.TestCases {
+		if tc.Name != testCase {
+			continue
+		}
+		params := make(map[string]string, len(tc.Params))
+		for k, p := range tc.Params {
+			if p.Default != nil {
+				params[k] = fmt.Sprint(p.Default)
+			}
+		}
+		return params, nil
+	}
+	return nil, fmt.Errorf("No test case %s in %s", testCase, path)
+}
+
+// composition holds the parts of a testground composition an in-process run
+// needs.
+type composition struct {
+	Global struct {
+		Case           string `toml:"case"`
+		TotalInstances int    `toml:"total_instances"`
+		Run            struct {
+			TestParams map[string]string `toml:"test_params"`
+		} `toml:"run"`
+	} `toml:"global"`
+	Groups []struct {
+		ID        string `toml:"id"`
+		Instances struct {
+			Count int `toml:"count"`
+		} `toml:"instances"`
+		Run struct {
+			TestParams map[string]string `toml:"test_params"`
+		} `toml:"run"`
+	} `toml:"groups"`
+}
+
+// ReadComposition reads a composition with a single group of instances into
+// the configuration of an in-process run. The manifest and outputs path are
+// left to the caller.
+func ReadComposition(path string) (InProcessConfig, error) {
+	var c composition
+	if _, err := toml.DecodeFile(path, &c); err != nil {
+		return InProcessConfig{}, fmt.Errorf("Error reading composition %s: %w", path, err)
+	}
+	if len(c.Groups) != 1 {
+		return InProcessConfig{}, fmt.Errorf("Only compositions with a single group can run in process, %s has %d", path, len(c.Groups))
+	}
+	group := c.Groups[0]
+
+	cfg := InProcessConfig{
+		TestCase:  c.Global.Case,
+		Instances: group.Instances.Count,
+		Params:    make(map[string]string),
+		GroupID:   group.ID,
+	}
+	if cfg.Instances == 0 {
+		cfg.Instances = c.Global.TotalInstances
+	}
+	for k, v := range c.Global.Run.TestParams {
+		cfg.Params[k] = v
+	}
+	for k, v := range group.Run.TestParams {
+		cfg.Params[k] = v
+	}
+	return cfg, nil
+}
@@ -0,0 +1,86 @@
+package test
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/testground/sdk-go/network"
+	"github.com/testground/sdk-go/runtime"
+	"github.com/testground/sdk-go/sync"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// Coordinator is what the instances of a test case use to find each other,
+// keep in step and set up their network. The testground one is backed by
+// the sync service and the sidecar; the in-process one, see RunInProcess,
+// runs all the instances in a single process and coordinates them in memory
+// with MemSync.
+type Coordinator interface {
+	// Publish publishes a payload to a topic and returns its sequence
+	// number, starting from 1.
+	Publish(ctx context.Context, topic *sync.Topic, payload interface{}) (int64, error)
+	// Subscribe sends every payload published to a topic, from the first
+	// one, to ch, a channel of the type of the topic. The subscription ends
+	// when ctx is done. The returned subscription may be nil, as it is with
+	// MemSync, so callers wait on ch and ctx only.
+	Subscribe(ctx context.Context, topic *sync.Topic, ch interface{}) (*sync.Subscription, error)
+	// SignalAndWait signals that the instance reached a state and waits for
+	// target instances to reach it.
+	SignalAndWait(ctx context.Context, state sync.State, target int) (int64, error)
+
+	// DataIP returns the IP of the instance on the data network.
+	DataIP() string
+	// ConfigureNetwork shapes the traffic of the instance.
+	ConfigureNetwork(ctx context.Context, runenv *runtime.RunEnv, nodetp utils.NodeType, tpindex int,
+		latency time.Duration, bandwidth int, jitterPct int) error
+	// Clock returns the clock the instance measures and waits with.
+	Clock() utils.Clock
+}
+
+// hostProvider is implemented by coordinators whose network the instances
+// can't listen on themselves, so they create their libp2p hosts.
+type hostProvider interface {
+	newHost(privKey crypto.PrivKey, addr multiaddr.Multiaddr) (host.Host, error)
+}
+
+// linkSimulator is implemented by coordinators that can simulate the links
+// between bitswap nodes, which they do if simLinks isn't nil.
+type linkSimulator interface {
+	simLinks() *utils.SimLinks
+}
+
+// sharedProcess is implemented by coordinators that run all the instances in
+// a single process, so the resource usage of the process is that of all of
+// them.
+type sharedProcess interface {
+	sharesProcess()
+}
+
+type testgroundCoordinator struct {
+	*sync.DefaultClient
+	nwClient *network.Client
+}
+
+// NewTestgroundCoordinator binds an instance run by testground to the sync
+// service and its sidecar.
+func NewTestgroundCoordinator(ctx context.Context, runenv *runtime.RunEnv) Coordinator {
+	client := sync.MustBoundClient(ctx, runenv)
+	return &testgroundCoordinator{client, network.NewClient(client, runenv)}
+}
+
+func (c *testgroundCoordinator) DataIP() string {
+	return c.nwClient.MustGetDataNetworkIP().String()
+}
+
+func (c *testgroundCoordinator) Clock() utils.Clock {
+	return utils.RealClock
+}
+
+func (c *testgroundCoordinator) ConfigureNetwork(ctx context.Context, runenv *runtime.RunEnv, nodetp utils.NodeType, tpindex int,
+	latency time.Duration, bandwidth int, jitterPct int) error {
+	return utils.SetupNetwork(ctx, runenv, c.nwClient, nodetp, tpindex, latency, bandwidth, jitterPct)
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fairnessTracker follows the bitswap ledgers of a node during a run and
+// summarizes how evenly it served its peers and how much it reciprocated.
+// It is safe for concurrent use.
+type fairnessTracker struct {
+	mu    sync.Mutex
+	start time.Time
+	last  time.Time
+	// busy is the time during which the node was sending to anyone.
+	busy  time.Duration
+	peers map[ledgerPeer]*peerService
+	// peerBehaviours tags the per peer metrics with the behaviour of the peer.
+	peerBehaviours map[ledgerPeer]string
+}
+
+type peerService struct {
+	// Ledger values when the run started and at the latest sample.
+	startSent, startRecv uint64
+	sent, recv           uint64
+	// share accumulates the fraction of the node's upload going to this
+	// peer, weighted by the length of each sampling interval.
+	share time.Duration
+}
+
+func newFairnessTracker(peerBehaviours map[ledgerPeer]string) *fairnessTracker {
+	return &fairnessTracker{peers: make(map[ledgerPeer]*peerService), peerBehaviours: peerBehaviours}
+}
+
+// sample records the ledgers of the node at the given time. The first sample
+// is the baseline of the run, so it should be taken right after the ledgers
+// are set up.
+func (f *fairnessTracker) sample(now time.Time, receipts []ledgerReceipt) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.start.IsZero() {
+		f.start, f.last = now, now
+		for _, r := range receipts {
+			f.peers[r.Peer] = &peerService{startSent: r.Sent, startRecv: r.Recv, sent: r.Sent, recv: r.Recv}
+		}
+		return
+	}
+
+	dt := now.Sub(f.last)
+	f.last = now
+	deltas := make(map[ledgerPeer]uint64, len(receipts))
+	var total uint64
+	for _, r := range receipts {
+		p, ok := f.peers[r.Peer]
+		if !ok {
+			p = &peerService{}
+			f.peers[r.Peer] = p
+		}
+		if r.Sent > p.sent {
+			deltas[r.Peer] = r.Sent - p.sent
+			total += r.Sent - p.sent
+		}
+		p.sent, p.recv = r.Sent, r.Recv
+	}
+	if total == 0 {
+		return
+	}
+	f.busy += dt
+	for peer, d := range deltas {
+		f.peers[peer].share += time.Duration(float64(dt) * float64(d) / float64(total))
+	}
+}
+
+// emit records the fairness metrics of the run. jain_fairness is Jain's index
+// over the upload throughput to every peer the node exchanged data with (1 when
+// all of them were served equally), debt_ratio_{min,p50,mean,max} summarize the
+// bitswap debt ratio (sent / (received + 1)) over all peers including previous
+// runs, and contribution_ratio is the bytes sent over the bytes received during
+// the run. Per peer it records bytes_contributed, bytes_consumed,
+// contribution_ratio, debt_ratio and service_share, the time-weighted fraction
+// of the node's upload that went to that peer.
+func (f *fairnessTracker) emit(recorder *metricsRecorder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	elapsed := f.last.Sub(f.start).Seconds()
+	var throughputs, debtRatios []float64
+	var contributed, consumed uint64
+	for peer, p := range f.peers {
+		sent, recv := delta(p.startSent, p.sent), delta(p.startRecv, p.recv)
+		contributed += sent
+		consumed += recv
+		debtRatio := float64(p.sent) / float64(p.recv+1)
+		debtRatios = append(debtRatios, debtRatio)
+
+		peerRecorder := recorder.with("peerType", peer.Nodetp).with("peerTypeIndex", peer.TpIndex)
+		if b, ok := f.peerBehaviours[peer]; ok {
+			peerRecorder = peerRecorder.with("peerBehaviour", b)
+		}
+		peerRecorder.Record("debt_ratio", debtRatio)
+		if sent == 0 && recv == 0 {
+			continue
+		}
+		if elapsed > 0 {
+			throughputs = append(throughputs, float64(sent)/elapsed)
+		}
+		peerRecorder.Record("bytes_contributed", float64(sent))
+		peerRecorder.Record("bytes_consumed", float64(recv))
+		if recv > 0 {
+			peerRecorder.Record("contribution_ratio", float64(sent)/float64(recv))
+		}
+		if f.busy > 0 {
+			peerRecorder.Record("service_share", float64(p.share)/float64(f.busy))
+		}
+	}
+
+	if idx, ok := jainIndex(throughputs); ok {
+		recorder.Record("jain_fairness", idx)
+	}
+	if consumed > 0 {
+		recorder.Record("contribution_ratio", float64(contributed)/float64(consumed))
+	}
+	if len(debtRatios) > 0 {
+		sort.Float64s(debtRatios)
+		var sum float64
+		for _, r := range debtRatios {
+			sum += r
+		}
+		recorder.Record("debt_ratio_min", debtRatios[0])
+		recorder.Record("debt_ratio_p50", percentile(debtRatios, 0.5))
+		recorder.Record("debt_ratio_mean", sum/float64(len(debtRatios)))
+		recorder.Record("debt_ratio_max", debtRatios[len(debtRatios)-1])
+	}
+}
+
+// delta returns how much a ledger counter grew during the run.
+func delta(start, end uint64) uint64 {
+	if end < start {
+		return 0
+	}
+	return end - start
+}
+
+// jainIndex computes (sum x)^2 / (n * sum x^2). It is undefined when nothing
+// was sent.
+func jainIndex(xs []float64) (float64, bool) {
+	var sum, sumSq float64
+	for _, x := range xs {
+		sum += x
+		sumSq += x * x
+	}
+	if sumSq == 0 {
+		return 0, false
+	}
+	return sum * sum / (float64(len(xs)) * sumSq), true
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating
+// between the closest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
@@ -1,104 +1,239 @@
 package test
 
-import "github.com/protocol/beyond-bitswap/testbed/testbed/utils"
-
-var initialSends = map[utils.NodeType]map[int]map[utils.NodeType]map[int]uint64{
-	utils.Leech: map[int]map[utils.NodeType]map[int]uint64{
-		0: {
-			utils.Seed: {
-				0: 1,
-			},
-		},
-		1: {
-			utils.Seed: {
-				0: 25000,
-			},
-		},
-		2: {
-			utils.Seed: {
-				0: 500000,
-			},
-		},
-	},
-	utils.Seed: {
-		0: {
-			utils.Leech: {
-				0: 1000,
-				1: 1000,
-				2: 1000,
-			},
-		},
-	},
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// ledgerPeer identifies a peer in the initial ledger state, e.g. "leech:1".
+type ledgerPeer struct {
+	Nodetp  utils.NodeType
+	TpIndex int
 }
 
-func getInitialSend(senderType utils.NodeType, senderIndex int, recvType utils.NodeType, recvIndex int) uint64 {
-	if _, ok := initialSends[senderType]; !ok {
-		return 0
+func parseLedgerPeer(s string) (ledgerPeer, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return ledgerPeer{}, fmt.Errorf("Invalid ledger peer '%s', expected <type>:<index>", s)
 	}
-	if _, ok := initialSends[senderType][senderIndex]; !ok {
-		return 0
+	nodetp, err := utils.ParseNodeType(parts[0])
+	if err != nil {
+		return ledgerPeer{}, err
 	}
-	if _, ok := initialSends[senderType][senderIndex][recvType]; !ok {
-		return 0
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ledgerPeer{}, fmt.Errorf("Invalid ledger peer index '%s'", parts[1])
 	}
-	if _, ok := initialSends[senderType][senderIndex][recvType][recvIndex]; !ok {
-		return 0
+	return ledgerPeer{nodetp, index}, nil
+}
+
+func (p ledgerPeer) String() string {
+	return fmt.Sprintf("%s:%d", strings.ToLower(p.Nodetp.String()), p.TpIndex)
+}
+
+func (p ledgerPeer) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *ledgerPeer) UnmarshalText(text []byte) error {
+	parsed, err := parseLedgerPeer(string(text))
+	if err != nil {
+		return err
 	}
+	*p = parsed
+	return nil
+}
+
+// initialLedger holds the number of bytes every peer has sent to every other
+// peer before the experiment starts.
+type initialLedger struct {
+	sends map[ledgerPeer]map[ledgerPeer]uint64
+}
 
-	return initialSends[senderType][senderIndex][recvType][recvIndex]
+func newInitialLedger() *initialLedger {
+	return &initialLedger{make(map[ledgerPeer]map[ledgerPeer]uint64)}
 }
 
-func setSend(senderType utils.NodeType, senderIndex int, recvType utils.NodeType, recvIndex int, bytes uint64) {
-	if _, ok := initialSends[senderType]; !ok {
-		return
+// loadInitialLedger builds the initial ledger state according to the
+// ledger_init parameter:
+// - param: parsed from ledger_init_values, e.g. "leech:1>seed:0=25000,seed:0>leech:1=1000"
+// - file: read from ledger_init_file (JSON or CSV) in data_dir
+// - random: every pair gets a random value up to ledger_init_bytes
+// - proportional: every peer has sent ledger_init_bytes times its type index + 1
+// - equal: every pair gets ledger_init_bytes
+// - none: all ledgers start empty
+//
+// Without ledger_init, the ledgers come from the parameters, as with the
+// default of the manifest.
+func loadInitialLedger(runenv *runtime.RunEnv, peers []utils.PeerInfo) (*initialLedger, error) {
+	source := "param"
+	if runenv.IsParamSet("ledger_init") {
+		source = runenv.StringParam("ledger_init")
 	}
-	if _, ok := initialSends[senderType][senderIndex]; !ok {
-		return
+
+	l := newInitialLedger()
+	switch source {
+	case "none":
+		return l, nil
+	case "param":
+		if !runenv.IsParamSet("ledger_init_values") {
+			return l, nil
+		}
+		return l, l.parse(runenv.StringParam("ledger_init_values"))
+	case "file":
+		path := runenv.StringParam("ledger_init_file")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(runenv.StringParam("data_dir"), path)
+		}
+		return l, l.readFile(path)
+	case "random", "proportional", "equal":
+	default:
+		return nil, fmt.Errorf("Initial ledger %s not implemented", source)
 	}
-	if _, ok := initialSends[senderType][senderIndex][recvType]; !ok {
-		return
+
+	if runenv.IntParam("ledger_init_bytes") < 0 {
+		return nil, fmt.Errorf("ledger_init_bytes must not be negative, got %d", runenv.IntParam("ledger_init_bytes"))
 	}
-	if _, ok := initialSends[senderType][senderIndex][recvType][recvIndex]; !ok {
-		return
+	bytes := uint64(runenv.IntParam("ledger_init_bytes"))
+	r := rand.New(rand.NewSource(int64(runenv.IntParam("ledger_init_seed"))))
+
+	// Every node must generate the same values, so walk the peers in a
+	// fixed order.
+	participants := make([]ledgerPeer, 0, len(peers))
+	for _, p := range peers {
+		participants = append(participants, ledgerPeer{p.Nodetp, p.TpIndex})
 	}
+	sort.Slice(participants, func(i, j int) bool {
+		if participants[i].Nodetp != participants[j].Nodetp {
+			return participants[i].Nodetp < participants[j].Nodetp
+		}
+		return participants[i].TpIndex < participants[j].TpIndex
+	})
 
-	if initialSends[senderType][senderIndex][recvType][recvIndex] < bytes {
-		initialSends[senderType][senderIndex][recvType][recvIndex] = bytes
+	for _, sender := range participants {
+		for _, recv := range participants {
+			if sender == recv {
+				continue
+			}
+			switch source {
+			case "random":
+				l.set(sender, recv, uint64(r.Int63n(int64(bytes)+1)))
+			case "proportional":
+				l.set(sender, recv, bytes*uint64(sender.TpIndex+1))
+			case "equal":
+				l.set(sender, recv, bytes)
+			}
+		}
 	}
+	return l, nil
 }
 
-var initialSendsTrade = map[int]map[int]uint64{
-	0: {
-		0: 1,
-	},
-	1: {
-		0: 25000,
-	},
-	2: {
-		0: 500000,
-	},
+func (l *initialLedger) get(sender ledgerPeer, recv ledgerPeer) uint64 {
+	return l.sends[sender][recv]
 }
 
-func getInitialSendTrade(senderIndex int, recvIndex int) uint64 {
-	if _, ok := initialSendsTrade[senderIndex]; !ok {
-		return 0
-	}
-	if _, ok := initialSendsTrade[senderIndex][recvIndex]; !ok {
-		return 0
+func (l *initialLedger) set(sender ledgerPeer, recv ledgerPeer, bytes uint64) {
+	if _, ok := l.sends[sender]; !ok {
+		l.sends[sender] = make(map[ledgerPeer]uint64)
 	}
+	l.sends[sender][recv] = bytes
+}
 
-	return initialSendsTrade[senderIndex][recvIndex]
+// parse reads a comma-separated list of <sender>><receiver>=<bytes> entries.
+func (l *initialLedger) parse(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kv := strings.Split(entry, "=")
+		if len(kv) != 2 {
+			return fmt.Errorf("Invalid ledger entry '%s'", entry)
+		}
+		pair := strings.Split(kv[0], ">")
+		if len(pair) != 2 {
+			return fmt.Errorf("Invalid ledger entry '%s'", entry)
+		}
+		if err := l.setFromStrings(pair[0], pair[1], kv[1]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func setSendTrade(senderIndex int, recvIndex int, bytes uint64) {
-	if _, ok := initialSendsTrade[senderIndex]; !ok {
-		return
+// readFile reads the ledger values from a JSON or CSV file. JSON files map
+// senders to receivers to bytes, e.g. {"leech:1": {"seed:0": 25000}}. CSV
+// files have one sender,receiver,bytes row per pair, with an optional header.
+func (l *initialLedger) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
 	}
-	if _, ok := initialSendsTrade[senderIndex][recvIndex]; !ok {
-		return
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var sends map[string]map[string]uint64
+		if err := json.NewDecoder(f).Decode(&sends); err != nil {
+			return fmt.Errorf("Error reading initial ledger %s: %w", path, err)
+		}
+		for sender, recvs := range sends {
+			for recv, bytes := range recvs {
+				if err := l.setFromStrings(sender, recv, strconv.FormatUint(bytes, 10)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case ".csv":
+		r := csv.NewReader(f)
+		r.FieldsPerRecord = 3
+		r.TrimLeadingSpace = true
+		for row := 0; ; row++ {
+			record, err := r.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("Error reading initial ledger %s: %w", path, err)
+			}
+			if row == 0 && record[0] == "sender" {
+				continue
+			}
+			if err := l.setFromStrings(record[0], record[1], record[2]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Initial ledger file %s must be JSON or CSV", path)
 	}
+}
 
-	if initialSendsTrade[senderIndex][recvIndex] < bytes {
-		initialSendsTrade[senderIndex][recvIndex] = bytes
+func (l *initialLedger) setFromStrings(sender string, recv string, bytes string) error {
+	s, err := parseLedgerPeer(sender)
+	if err != nil {
+		return err
+	}
+	r, err := parseLedgerPeer(recv)
+	if err != nil {
+		return err
+	}
+	b, err := strconv.ParseUint(strings.TrimSpace(bytes), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid ledger bytes '%s' for %s>%s", bytes, sender, recv)
 	}
+	l.set(s, r, b)
+	return nil
 }
@@ -0,0 +1,276 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/testground/sdk-go/runtime"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// InProcessConfig is a run of a test case with all of its instances in this
+// process, without testground.
+type InProcessConfig struct {
+	// TestCase is transfer, trade or replay.
+	TestCase  string
+	Instances int
+	// Params are the test parameters, on top of the defaults of the test case
+	// in Manifest.
+	Params   map[string]string
+	Manifest string
+	// The outputs of every instance are written to
+	// OutputsPath/GroupID/<instance>, like testground collects them.
+	OutputsPath string
+	GroupID     string
+	// Simulate runs bitswap transfers on virtual time, see utils.SimClock,
+	// with the links simulated by utils.SimLinks. Neither the instances nor
+	// the exchange take part in the clock, so virtual time moves on once
+	// nothing went on for Settle of wall time. Simulations don't depend on
+	// the load of the machine as much as wall time runs, but aren't
+	// guaranteed to repeat exactly.
+	Simulate bool
+	Settle   time.Duration
+}
+
+// simEpoch is the virtual time simulations start at.
+var simEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+const defaultSettle = 10 * time.Millisecond
+
+type inProcessCase func(ctx context.Context, runenv *runtime.RunEnv, coord Coordinator) error
+
+var inProcessCases = map[string]inProcessCase{
+	"transfer": transfer,
+	"trade":    trade,
+	"replay":   replay,
+}
+
+// RunInProcess runs a test case with its instances connected over a libp2p
+// mocknet and coordinated in memory. Only bitswap and graphsync nodes can run
+// in process, as the other nodes create their own hosts. The first instance
+// to fail stops the others.
+func RunInProcess(ctx context.Context, cfg InProcessConfig) error {
+	testCase, ok := inProcessCases[cfg.TestCase]
+	if !ok {
+		return fmt.Errorf("Test case %s can't run in process", cfg.TestCase)
+	}
+	if cfg.Instances < 2 {
+		return fmt.Errorf("Need at least 2 instances, got %d", cfg.Instances)
+	}
+	params, err := readManifestDefaults(cfg.Manifest, cfg.TestCase)
+	if err != nil {
+		return err
+	}
+	for k, v := range cfg.Params {
+		params[k] = v
+	}
+	if nodeType := params["node_type"]; nodeType != "bitswap" && nodeType != "graphsync" {
+		return fmt.Errorf("Node type %s can't run in process", nodeType)
+	}
+	if cfg.GroupID == "" {
+		cfg.GroupID = "single"
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	net := newInprocNetwork(ctx)
+	var clock utils.Clock = utils.RealClock
+	if cfg.Simulate {
+		if params["node_type"] != "bitswap" {
+			return fmt.Errorf("Node type %s can't be simulated", params["node_type"])
+		}
+		if cfg.Settle <= 0 {
+			cfg.Settle = defaultSettle
+		}
+		simClock := utils.NewSimClock(simEpoch, cfg.Settle)
+		defer simClock.Stop()
+		net.sim = utils.NewSimLinks(simClock)
+		clock = simClock
+	}
+	memsync := NewMemSync()
+	runID := fmt.Sprintf("inprocess-%d", clock.Now().Unix())
+	for i := 0; i < cfg.Instances; i++ {
+		outputs := filepath.Join(cfg.OutputsPath, cfg.GroupID, strconv.Itoa(i))
+		if err := os.MkdirAll(outputs, 0755); err != nil {
+			return err
+		}
+		instanceParams := make(map[string]string, len(params))
+		for k, v := range params {
+			instanceParams[k] = v
+		}
+		runenv := runtime.NewRunEnv(runtime.RunParams{
+			TestPlan:               "testbed",
+			TestCase:               cfg.TestCase,
+			TestRun:                runID,
+			TestInstanceCount:      cfg.Instances,
+			TestInstanceParams:     instanceParams,
+			TestGroupID:            cfg.GroupID,
+			TestGroupInstanceCount: cfg.Instances,
+			TestOutputsPath:        outputs,
+		})
+		coord := &inprocCoordinator{memsync, net, &linkShape{}, clock}
+
+		i := i
+		g.Go(func() error {
+			runenv.RecordStart()
+			err := testCase(ctx, runenv, coord)
+			if err != nil {
+				runenv.RecordFailure(err)
+			} else {
+				runenv.RecordSuccess()
+			}
+			if cerr := runenv.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("Instance %d: %w", i, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// inprocCoordinator coordinates an instance of an in-process run in memory,
+// and creates its hosts on the mocknet of the run.
+type inprocCoordinator struct {
+	*MemSync
+	net *inprocNetwork
+	// Shape of the traffic of the instance, shared by all the hosts it
+	// creates.
+	shape *linkShape
+	clock utils.Clock
+}
+
+func (c *inprocCoordinator) newHost(privKey crypto.PrivKey, addr multiaddr.Multiaddr) (host.Host, error) {
+	return c.net.addHost(privKey, addr, c.shape)
+}
+
+func (c *inprocCoordinator) Clock() utils.Clock {
+	return c.clock
+}
+
+func (c *inprocCoordinator) simLinks() *utils.SimLinks {
+	return c.net.sim
+}
+
+func (c *inprocCoordinator) sharesProcess() {}
+
+// loopbackIP is the data network IP of the instances of an in-process run.
+const loopbackIP = "127.0.0.1"
+
+// DataIP is only used by TCP transfers, over the loopback interface.
+func (c *inprocCoordinator) DataIP() string {
+	return loopbackIP
+}
+
+// ConfigureNetwork shapes the links of the instance like the sidecar would
+// shape its traffic. Jitter isn't supported by mocknet links.
+func (c *inprocCoordinator) ConfigureNetwork(ctx context.Context, runenv *runtime.RunEnv, nodetp utils.NodeType, tpindex int,
+	baseLatency time.Duration, bandwidth int, jitterPct int) error {
+	latency, err := utils.NodeLatency(runenv, nodetp, tpindex, baseLatency)
+	if err != nil {
+		return err
+	}
+	runenv.RecordMessage("%s %d has %s latency and %dMB bandwidth", nodetp, tpindex, latency, bandwidth)
+	// bandwidth_mb is in Mib/s, mocknet links in bytes per second.
+	c.net.reshape(c.shape, linkShape{latency, float64(bandwidth) * 1024 * 1024 / 8})
+	return nil
+}
+
+type linkShape struct {
+	latency time.Duration
+	// In bytes per second, or unlimited if 0.
+	bandwidth float64
+}
+
+// inprocNetwork is a mocknet linking every host of an in-process run with
+// all the others. In simulations the mocknet links are left unshaped and the
+// shapes go to the simulated links instead.
+type inprocNetwork struct {
+	mu     sync.Mutex
+	mn     mocknet.Mocknet
+	shapes map[peer.ID]*linkShape
+	sim    *utils.SimLinks
+}
+
+func newInprocNetwork(ctx context.Context) *inprocNetwork {
+	return &inprocNetwork{mn: mocknet.New(ctx), shapes: make(map[peer.ID]*linkShape)}
+}
+
+func (n *inprocNetwork) addHost(privKey crypto.PrivKey, addr multiaddr.Multiaddr, shape *linkShape) (host.Host, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	h, err := n.mn.AddPeer(privKey, addr)
+	if err != nil {
+		return nil, err
+	}
+	n.shapes[h.ID()] = shape
+	if n.sim != nil {
+		n.sim.SetShape(h.ID(), shape.latency, shape.bandwidth)
+	}
+	for other := range n.shapes {
+		if other == h.ID() {
+			continue
+		}
+		if _, err := n.mn.LinkPeers(h.ID(), other); err != nil {
+			return nil, err
+		}
+		n.shapeLinksLocked(h.ID(), other)
+	}
+	return h, nil
+}
+
+// reshape changes the shape of the traffic of an instance, on the links of
+// all of its hosts.
+func (n *inprocNetwork) reshape(shape *linkShape, to linkShape) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	*shape = to
+	for p, s := range n.shapes {
+		if s != shape {
+			continue
+		}
+		if n.sim != nil {
+			n.sim.SetShape(p, to.latency, to.bandwidth)
+			continue
+		}
+		for other := range n.shapes {
+			if other != p {
+				n.shapeLinksLocked(p, other)
+			}
+		}
+	}
+}
+
+// shapeLinksLocked sets the options of the links between two hosts from the
+// shapes of both ends. The sidecar delays the traffic each node sends, while
+// mocknet delays both directions of a link alike, so links get the mean
+// latency of their ends, which keeps the round trip time, and the lower
+// bandwidth.
+func (n *inprocNetwork) shapeLinksLocked(a, b peer.ID) {
+	if n.sim != nil {
+		return
+	}
+	sa, sb := n.shapes[a], n.shapes[b]
+	opts := mocknet.LinkOptions{Latency: (sa.latency + sb.latency) / 2, Bandwidth: sa.bandwidth}
+	if opts.Bandwidth == 0 || (sb.bandwidth > 0 && sb.bandwidth < opts.Bandwidth) {
+		opts.Bandwidth = sb.bandwidth
+	}
+	for _, l := range n.mn.LinksBetweenPeers(a, b) {
+		l.SetOptions(opts)
+	}
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/results"
+)
+
+// TestInProcessTransfer runs the transfer test case in process, so the seed
+// publishes the root CID of the file it added through MemSync and the leech
+// reads it from there before fetching the file.
+func TestInProcessTransfer(t *testing.T) {
+	for _, nodeType := range []string{"bitswap", "graphsync"} {
+		nodeType := nodeType
+		t.Run(nodeType, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			dir, err := ioutil.TempDir("", "inprocess-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			err = RunInProcess(ctx, InProcessConfig{
+				TestCase:  "transfer",
+				Instances: 2,
+				Params: map[string]string{
+					"node_type":        nodeType,
+					"file_size":        "1048576",
+					"timeout_secs":     "60",
+					"run_timeout_secs": "60",
+					"sample_format":    "none",
+				},
+				Manifest:    "../manifest.toml",
+				OutputsPath: dir,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, _, err := results.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fetches := results.Filter(res, map[string]string{"nodeType": "Leech"})
+			found := false
+			for _, r := range fetches {
+				if r.Name == "time_to_fetch" {
+					found = true
+					if r.Value <= 0 {
+						t.Errorf("Leech recorded time_to_fetch %v", r.Value)
+					}
+				}
+			}
+			if !found {
+				t.Error("Leech recorded no time_to_fetch")
+			}
+		})
+	}
+}
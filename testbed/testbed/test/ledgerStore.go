@@ -0,0 +1,206 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// ledgerReceipt is this node's view of its bitswap ledger with another peer.
+type ledgerReceipt struct {
+	Peer      ledgerPeer `json:"peer"`
+	PeerID    string     `json:"peer_id"`
+	Sent      uint64     `json:"sent"`
+	Recv      uint64     `json:"recv"`
+	Value     float64    `json:"value"`
+	Exchanged uint64     `json:"exchanged"`
+}
+
+// ledgerSnapshot is the on-disk format of a ledger store.
+type ledgerSnapshot struct {
+	Self     ledgerPeer      `json:"self"`
+	Run      string          `json:"run"`
+	Receipts []ledgerReceipt `json:"receipts"`
+}
+
+// ledgerStore keeps the bitswap ledgers of a node between runs and files, so
+// every run starts from the exact ledger state the previous one ended with.
+// It is safe for concurrent use.
+type ledgerStore struct {
+	mu       sync.RWMutex
+	self     ledgerPeer
+	run      string
+	receipts map[ledgerPeer]ledgerReceipt
+}
+
+// newLedgerStore creates the ledger store of a node. With ledger_init=snapshot
+// the store is loaded from the snapshot this node wrote in a previous
+// experiment (see ledger_snapshot_dir); otherwise it starts from the initial
+// ledger state.
+func newLedgerStore(runenv *runtime.RunEnv, self ledgerPeer, peers []utils.PeerInfo) (*ledgerStore, error) {
+	s := &ledgerStore{self: self, receipts: make(map[ledgerPeer]ledgerReceipt)}
+
+	if runenv.IsParamSet("ledger_init") && runenv.StringParam("ledger_init") == "snapshot" {
+		path := filepath.Join(runenv.StringParam("ledger_snapshot_dir"), s.fileName())
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(runenv.StringParam("data_dir"), path)
+		}
+		return s, s.load(path)
+	}
+
+	initial, err := loadInitialLedger(runenv, peers)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range peers {
+		other := ledgerPeer{p.Nodetp, p.TpIndex}
+		if other == self {
+			continue
+		}
+		sent, recv := initial.get(self, other), initial.get(other, self)
+		if sent == 0 && recv == 0 {
+			continue
+		}
+		s.receipts[other] = ledgerReceipt{Peer: other, PeerID: p.Addr.ID.String(), Sent: sent, Recv: recv}
+	}
+	return s, nil
+}
+
+func (s *ledgerStore) fileName() string {
+	return fmt.Sprintf("ledger-%s-%d.json", s.self.Nodetp, s.self.TpIndex)
+}
+
+// get returns the stored receipt for a peer.
+func (s *ledgerStore) get(p ledgerPeer) (ledgerReceipt, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.receipts[p]
+	return r, ok
+}
+
+// apply sets up the bitswap ledgers of this node with every other peer from
+// the stored receipts. Ledgers with the whitewashed peers, which came back
+// under a new peer ID, start empty on both sides.
+func (s *ledgerStore) apply(runenv *runtime.RunEnv, t *NodeTestData, bsnode *utils.BitswapNode, whitewashed map[ledgerPeer]bool) {
+	if whitewashed[s.self] {
+		return
+	}
+	for _, peerInfo := range t.peerInfos {
+		if t.isSelf(peerInfo) || whitewashed[ledgerPeer{peerInfo.Nodetp, peerInfo.TpIndex}] {
+			continue
+		}
+		receipt, ok := s.get(ledgerPeer{peerInfo.Nodetp, peerInfo.TpIndex})
+		if !ok {
+			continue
+		}
+		if receipt.Sent != 0 {
+			runenv.RecordMessage("Setting sent value in ledger to %d bytes for %s %d (peer %s)", receipt.Sent, peerInfo.Nodetp, peerInfo.TpIndex, peerInfo.Addr.ID.String())
+			bsnode.SetLedgerSentBytes(peerInfo.Addr.ID, int(receipt.Sent))
+		}
+		if receipt.Recv != 0 {
+			runenv.RecordMessage("Setting received value in ledger to %d bytes for %s %d (peer %s)", receipt.Recv, peerInfo.Nodetp, peerInfo.TpIndex, peerInfo.Addr.ID.String())
+			bsnode.Bitswap.SetLedgerReceivedBytes(peerInfo.Addr.ID, int(receipt.Recv))
+		}
+	}
+}
+
+// capture replaces the stored receipts with the current bitswap ledgers of
+// this node. It must be called before the peers are disconnected.
+func (s *ledgerStore) capture(runID string, t *NodeTestData, bsnode *utils.BitswapNode) {
+	receipts := make(map[ledgerPeer]ledgerReceipt)
+	for _, r := range t.ledgerReceipts(bsnode) {
+		receipts[r.Peer] = r
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run = runID
+	s.receipts = receipts
+}
+
+// snapshot returns a copy of the stored receipts sorted by peer.
+func (s *ledgerStore) snapshot() ledgerSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := ledgerSnapshot{Self: s.self, Run: s.run}
+	for _, r := range s.receipts {
+		snap.Receipts = append(snap.Receipts, r)
+	}
+	sort.Slice(snap.Receipts, func(i, j int) bool {
+		a, b := snap.Receipts[i].Peer, snap.Receipts[j].Peer
+		if a.Nodetp != b.Nodetp {
+			return a.Nodetp < b.Nodetp
+		}
+		return a.TpIndex < b.TpIndex
+	})
+	return snap
+}
+
+// save writes the stored receipts to the test outputs of this node, from where
+// they can be loaded with ledger_init=snapshot.
+func (s *ledgerStore) save(runenv *runtime.RunEnv) error {
+	data, err := json.MarshalIndent(s.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(runenv.TestOutputsPath, s.fileName()), data, 0644)
+}
+
+func (s *ledgerStore) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap ledgerSnapshot
+	if err := json.NewDecoder(f).Decode(&snap); err != nil {
+		return fmt.Errorf("Error reading ledger snapshot %s: %w", path, err)
+	}
+	if snap.Self != s.self {
+		return fmt.Errorf("Ledger snapshot %s belongs to %s, not %s", path, snap.Self, s.self)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run = snap.Run
+	for _, r := range snap.Receipts {
+		s.receipts[r.Peer] = r
+	}
+	return nil
+}
+
+// ledgerReceipts reads the current bitswap ledgers of this node with every
+// other peer, under the peer ID each has in this run.
+func (t *NodeTestData) ledgerReceipts(bsnode *utils.BitswapNode) []ledgerReceipt {
+	receipts := make([]ledgerReceipt, 0, len(t.peerInfos))
+	for _, peerInfo := range t.peerInfos {
+		if t.isSelf(peerInfo) {
+			continue
+		}
+		receipt := bsnode.LedgerForPeer(peerInfo.Addr.ID)
+		receipts = append(receipts, ledgerReceipt{
+			Peer:      ledgerPeer{peerInfo.Nodetp, peerInfo.TpIndex},
+			PeerID:    peerInfo.Addr.ID.String(),
+			Sent:      receipt.Sent,
+			Recv:      receipt.Recv,
+			Value:     receipt.Value,
+			Exchanged: receipt.Exchanged,
+		})
+	}
+	return receipts
+}
+
+// isSelf reports whether the peer info belongs to this node. Peers are matched
+// by type and type index, since a whitewasher changes its peer ID.
+func (t *NodeTestData) isSelf(peerInfo utils.PeerInfo) bool {
+	return peerInfo.Nodetp == t.nodetp && peerInfo.TpIndex == t.tpindex
+}
@@ -0,0 +1,125 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+
+	tgsync "github.com/testground/sdk-go/sync"
+)
+
+// MemSync is a sync service for instances running in the same process. Like
+// with the testground sync service, payloads are sent as JSON, so instances
+// never share them, and subscribers get every payload published to a topic
+// from the first one on. It has no subscription handles: subscriptions end,
+// closing their channel, when their context is done.
+type MemSync struct {
+	mu     sync.Mutex
+	topics map[tgsync.Topic][][]byte
+	states map[tgsync.State]int64
+	// changed is closed and replaced on every publish and signal.
+	changed chan struct{}
+}
+
+// NewMemSync returns a sync service to share among the instances of a run.
+func NewMemSync() *MemSync {
+	return &MemSync{
+		topics:  make(map[tgsync.Topic][][]byte),
+		states:  make(map[tgsync.State]int64),
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *MemSync) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *MemSync) Publish(ctx context.Context, topic *tgsync.Topic, payload interface{}) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("Error encoding payload: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.topics[*topic] = append(s.topics[*topic], data)
+	s.notifyLocked()
+	return int64(len(s.topics[*topic])), nil
+}
+
+func (s *MemSync) Subscribe(ctx context.Context, topic *tgsync.Topic, ch interface{}) (*tgsync.Subscription, error) {
+	chv := reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan || chv.Type().ChanDir()&reflect.SendDir == 0 {
+		return nil, fmt.Errorf("Subscriptions need a channel to send to, got %T", ch)
+	}
+	elem := chv.Type().Elem()
+	done := reflect.ValueOf(ctx.Done())
+
+	go func() {
+		defer chv.Close()
+		for i := 0; ; {
+			s.mu.Lock()
+			payloads, changed := s.topics[*topic], s.changed
+			s.mu.Unlock()
+
+			if i == len(payloads) {
+				select {
+				case <-changed:
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+			v, err := decodePayload(payloads[i], elem)
+			if err != nil {
+				// Payloads that don't fit the channel would fail the
+				// testground subscription too.
+				return
+			}
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: chv, Send: v},
+				{Dir: reflect.SelectRecv, Chan: done},
+			})
+			if chosen == 1 {
+				return
+			}
+			i++
+		}
+	}()
+	return nil, nil
+}
+
+// decodePayload decodes a payload into a new value of the element type of a
+// subscription channel, usually a pointer.
+func decodePayload(data []byte, typ reflect.Type) (reflect.Value, error) {
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem())
+		return v, json.Unmarshal(data, v.Interface())
+	}
+	v := reflect.New(typ)
+	return v.Elem(), json.Unmarshal(data, v.Interface())
+}
+
+func (s *MemSync) SignalAndWait(ctx context.Context, state tgsync.State, target int) (int64, error) {
+	s.mu.Lock()
+	s.states[state]++
+	seq := s.states[state]
+	s.notifyLocked()
+	s.mu.Unlock()
+
+	for {
+		s.mu.Lock()
+		count, changed := s.states[state], s.changed
+		s.mu.Unlock()
+		if count >= int64(target) {
+			return seq, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return seq, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tgsync "github.com/testground/sdk-go/sync"
+)
+
+type testPayload struct {
+	N int
+}
+
+func TestMemSyncPublishSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := NewMemSync()
+	topic := tgsync.NewTopic("payloads", &testPayload{})
+
+	for i := 1; i <= 3; i++ {
+		seq, err := s.Publish(ctx, topic, &testPayload{i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != int64(i) {
+			t.Errorf("Payload %d published with sequence number %d", i, seq)
+		}
+	}
+
+	// Every subscriber gets the payloads from the first one on, including
+	// the ones published after it subscribed, as values of its own.
+	chs := []chan *testPayload{make(chan *testPayload), make(chan *testPayload)}
+	for _, ch := range chs {
+		if _, err := s.Subscribe(ctx, topic, ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Publish(ctx, topic, &testPayload{4}); err != nil {
+		t.Fatal(err)
+	}
+	var first []*testPayload
+	for c, ch := range chs {
+		for i := 1; i <= 4; i++ {
+			select {
+			case p := <-ch:
+				if p.N != i {
+					t.Fatalf("Subscriber %d got payload %d, want %d", c, p.N, i)
+				}
+				if c == 0 {
+					first = append(first, p)
+				} else if p == first[i-1] {
+					t.Errorf("Subscribers share payload %d", i)
+				}
+			case <-ctx.Done():
+				t.Fatalf("Subscriber %d got %d payloads, want 4", c, i-1)
+			}
+		}
+	}
+
+	if _, err := s.Subscribe(ctx, topic, 1); err == nil {
+		t.Error("Subscribed with a value that isn't a channel")
+	}
+}
+
+func TestMemSyncSubscribeCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewMemSync()
+	topic := tgsync.NewTopic("payloads", &testPayload{})
+	ch := make(chan *testPayload)
+	if _, err := s.Subscribe(ctx, topic, ch); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("Got a payload nobody published")
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Subscription channel not closed once its context was done")
+	}
+}
+
+func TestMemSyncSignalAndWait(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s := NewMemSync()
+	state := tgsync.State("ready")
+
+	type result struct {
+		seq int64
+		err error
+	}
+	results := make(chan result, 3)
+	signal := func() {
+		seq, err := s.SignalAndWait(ctx, state, 3)
+		results <- result{seq, err}
+	}
+	go signal()
+	go signal()
+	select {
+	case <-results:
+		t.Fatal("An instance stopped waiting before all 3 signaled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	go signal()
+	seqs := make(map[int64]bool)
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		seqs[r.seq] = true
+	}
+	for seq := int64(1); seq <= 3; seq++ {
+		if !seqs[seq] {
+			t.Errorf("No instance got sequence number %d, got %v", seq, seqs)
+		}
+	}
+
+	// Later instances don't wait for a state that was already reached.
+	if seq, err := s.SignalAndWait(ctx, state, 3); err != nil || seq != 4 {
+		t.Errorf("Late signal got sequence number %d and error %v, want 4 and none", seq, err)
+	}
+}
+
+func TestMemSyncSignalAndWaitCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s := NewMemSync()
+	if _, err := s.SignalAndWait(ctx, "never", 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Waiting for a state nobody else reaches returned %v, want %v", err, context.DeadlineExceeded)
+	}
+}
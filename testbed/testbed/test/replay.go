@@ -0,0 +1,307 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	"github.com/testground/sdk-go/run"
+	"github.com/testground/sdk-go/runtime"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+	"github.com/protocol/beyond-bitswap/testbed/testbed/workload"
+)
+
+// Replay replays a recorded fetch workload: seeds publish the items of the
+// trace and every leech requests the items it requested, at the same offsets
+// and on the same network, so exchanges can be compared on identical
+// workloads.
+func Replay(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
+	ctx := context.Background()
+	return replay(ctx, runenv, NewTestgroundCoordinator(ctx, runenv))
+}
+
+func replay(ctx context.Context, runenv *runtime.RunEnv, coord Coordinator) error {
+	// Test Parameters
+	testvars, err := getEnvVars(runenv)
+	if err != nil {
+		return err
+	}
+	trace, err := readReplayTrace(runenv, testvars)
+	if err != nil {
+		return err
+	}
+	dataDir := runenv.StringParam("data_dir")
+	items := make([]utils.TestFile, len(trace.Items))
+	for i, item := range trace.Items {
+		if items[i], err = itemFile(item, dataDir); err != nil {
+			return err
+		}
+	}
+	nodeType := runenv.StringParam("node_type")
+
+	/// --- Set up
+	ctx, cancel := context.WithTimeout(ctx, testvars.Timeout)
+	defer cancel()
+	baseT, err := InitializeTest(ctx, runenv, testvars, coord)
+	if err != nil {
+		return err
+	}
+	nodeInitializer, ok := supportedNodes[nodeType]
+	if !ok {
+		return fmt.Errorf("unsupported node type: %s", nodeType)
+	}
+	t, err := nodeInitializer(ctx, runenv, testvars, baseT)
+	if err != nil {
+		return err
+	}
+	signalAndWaitForAll := t.signalAndWaitForAll
+
+	// Start still alive process if enabled
+	t.stillAlive(runenv, testvars)
+
+	// The network of the trace replaces the one of the test parameters.
+	testParams := TestPermutation{
+		Bandwidth: trace.Topology.BandwidthMB,
+		Latency:   time.Duration(trace.Topology.LatencyMS) * time.Millisecond,
+		JitterPct: trace.Topology.JitterPct,
+	}
+	if err := t.setupNetwork(ctx, runenv, testParams); err != nil {
+		return fmt.Errorf("Failed to set up network: %v", err)
+	}
+
+	// Wait for all nodes to be ready to publish the items
+	err = signalAndWaitForAll("start-replay-publish")
+	if err != nil {
+		return err
+	}
+
+	rootCids := make([]cid.Cid, len(items))
+	for i, f := range items {
+		switch t.nodetp {
+		case utils.Seed:
+			rootCids[i], err = t.addPublishFile(ctx, i, f, runenv, testvars)
+		default:
+			rootCids[i], err = t.readFile(ctx, i, runenv, testvars)
+		}
+		if err != nil {
+			return err
+		}
+		if rootCids[i].Defined() && rootCids[i].String() != trace.Items[i].CID {
+			runenv.RecordMessage("Item %d is %s here, it was %s when recorded", i, rootCids[i], trace.Items[i].CID)
+		}
+	}
+
+	runenv.RecordMessage("%d workload items injest complete...", len(rootCids))
+	// Wait for all nodes to be ready to dial
+	err = signalAndWaitForAll("injest-complete")
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, f := range items {
+		size += f.Size()
+	}
+
+	for runNum := 1; runNum < testvars.RunCount+1; runNum++ {
+		// Reset the timeout for each run
+		ctx, cancel := context.WithTimeout(ctx, testvars.RunTimeout)
+		defer cancel()
+
+		runID := fmt.Sprintf("%d", runNum)
+
+		// Wait for all nodes to be ready to start the run
+		err = signalAndWaitForAll("start-run-" + runID)
+		if err != nil {
+			return err
+		}
+
+		dialed, err := t.dialFn(ctx, t.node.Host(), t.nodetp, t.peerInfos, testvars.MaxConnectionRate)
+		if err != nil {
+			return err
+		}
+		runenv.RecordMessage("Dialed %d other nodes", len(dialed))
+
+		// Wait for all nodes to be connected
+		err = signalAndWaitForAll("connect-complete-" + runID)
+		if err != nil {
+			return err
+		}
+
+		/// --- Start test
+
+		// Measure the resources the node uses while exchanging data.
+		resources, err := utils.NewResourceMeter()
+		if err != nil {
+			return err
+		}
+
+		recorder := newMetricsRecorder(runenv, runNum, t.seq, t.grpseq, nodeType, testParams.Latency,
+			testParams.Bandwidth, int(size), t.nodetp, t.tpindex, testvars.MaxConnectionRate)
+
+		var fetched []cid.Cid
+		if t.nodetp == utils.Leech {
+			var stats *replayStats
+			stats, fetched, err = t.replayRequests(ctx, runenv, testvars, trace, rootCids, recorder)
+			if err != nil {
+				return err
+			}
+			stats.emit(recorder)
+		}
+
+		// Wait for all leeches to be done with their requests
+		err = signalAndWaitForAll("replay-complete-" + runID)
+		if err != nil {
+			return err
+		}
+
+		/// --- Report stats
+		t.emitBandwidth(recorder)
+		if err := t.emitResources(resources, recorder); err != nil {
+			return err
+		}
+		if err := t.node.EmitMetrics(recorder); err != nil {
+			return err
+		}
+		runenv.RecordMessage("Finishing emitting metrics. Starting to clean...")
+
+		if err := t.disconnect(runenv); err != nil {
+			return err
+		}
+		for _, c := range fetched {
+			if err := t.node.ClearDatastore(ctx, c); err != nil {
+				return fmt.Errorf("Error clearing datastore: %w", err)
+			}
+		}
+	}
+
+	for _, c := range rootCids {
+		if !c.Defined() {
+			continue
+		}
+		if err := t.cleanupFile(ctx, c); err != nil {
+			return err
+		}
+	}
+	err = t.close()
+	if err != nil {
+		return err
+	}
+
+	runenv.RecordMessage("Ending testcase")
+	return nil
+}
+
+// readReplayTrace reads the trace in replay_trace, relative to data_dir, and
+// checks that this run has its topology.
+func readReplayTrace(runenv *runtime.RunEnv, testvars *TestVars) (*workload.Trace, error) {
+	path := runenv.StringParam("replay_trace")
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(runenv.StringParam("data_dir"), path)
+	}
+	trace, err := workload.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	topology := trace.Topology
+	if runenv.TestInstanceCount != topology.Instances() || testvars.LeechCount != topology.Leeches || testvars.PassiveCount != topology.Passives {
+		return nil, fmt.Errorf("Workload %s needs %d instances with %d leeches and %d passive nodes, got %d instances with %d leeches and %d passive nodes",
+			path, topology.Instances(), topology.Leeches, topology.Passives,
+			runenv.TestInstanceCount, testvars.LeechCount, testvars.PassiveCount)
+	}
+	runenv.RecordMessage("Replaying %d requests of %d items from %s", len(trace.Requests), len(trace.Items), path)
+	return trace, nil
+}
+
+type replayStats struct {
+	requests int
+	fails    int
+	bytes    int64
+	elapsed  time.Duration
+}
+
+func (s *replayStats) emit(recorder utils.MetricsRecorder) {
+	recorder.Record("replay_requests", float64(s.requests))
+	recorder.Record("replay_fails", float64(s.fails))
+	recorder.Record("bytes_fetched", float64(s.bytes))
+	if s.elapsed > 0 {
+		recorder.Record("throughput", float64(s.bytes)/s.elapsed.Seconds())
+	}
+}
+
+// replayRequests makes the requests of this leech, each at its offset from
+// now, recording the latency of every request. Requests overlap like they
+// did when recorded. It returns the items fetched, to be dropped from the
+// local store once every leech is done.
+func (t *NodeTestData) replayRequests(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars,
+	trace *workload.Trace, rootCids []cid.Cid, recorder *metricsRecorder) (*replayStats, []cid.Cid, error) {
+	clock := t.coord.Clock()
+	stats := &replayStats{}
+	var mu sync.Mutex
+	fetched := make(map[cid.Cid]bool)
+
+	g, gctx := errgroup.WithContext(ctx)
+	start := clock.Now()
+	for reqNum, req := range trace.Requests {
+		if req.Leech != t.tpindex {
+			continue
+		}
+		reqNum, req := reqNum, req
+		g.Go(func() error {
+			select {
+			case <-clock.After(req.Offset() - clock.Since(start)):
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+
+			runenv.RecordMessage("Request %d: fetching item %d", reqNum, req.Item)
+			reqStart := clock.Now()
+			ctxFetch, cancel := context.WithTimeout(gctx, testvars.RunTimeout/2)
+			defer cancel()
+			rcvFile, err := t.node.Fetch(ctxFetch, rootCids[req.Item], t.peerInfos)
+			if err != nil {
+				runenv.RecordMessage("Error fetching item %d: %v", req.Item, err)
+				mu.Lock()
+				stats.requests++
+				stats.fails++
+				mu.Unlock()
+				return nil
+			}
+			// Walk the whole file so lazy fetchers pull every block.
+			path := filepath.Join(os.TempDir(), fmt.Sprintf("replay-%d-%d", t.tpindex, reqNum))
+			if err := files.WriteTo(rcvFile, path); err != nil {
+				return err
+			}
+			latency := clock.Since(reqStart)
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			s, _ := rcvFile.Size()
+
+			mu.Lock()
+			defer mu.Unlock()
+			stats.requests++
+			stats.bytes += s
+			fetched[rootCids[req.Item]] = true
+			recorder.with("item", req.Item).with("request", reqNum).Record("request_latency", float64(latency))
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	stats.elapsed = clock.Since(start)
+
+	var cids []cid.Cid
+	for c := range fetched {
+		cids = append(cids, c)
+	}
+	return stats, cids, nil
+}
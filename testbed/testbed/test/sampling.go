@@ -0,0 +1,84 @@
+package test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// newSampler creates the sampler of this node. Samples are written to the
+// test outputs as samples-<type>-<index>, in the format set by sample_format.
+func (t *NodeTestData) newSampler(runenv *runtime.RunEnv) (*utils.Sampler, error) {
+	interval := 100 * time.Millisecond
+	if runenv.IsParamSet("sample_interval_ms") {
+		interval = time.Duration(runenv.IntParam("sample_interval_ms")) * time.Millisecond
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("sample_interval_ms must be positive")
+	}
+	format := "jsonl"
+	if runenv.IsParamSet("sample_format") {
+		format = runenv.StringParam("sample_format")
+	}
+	if format == "none" {
+		return utils.NewSampler(t.coord.Clock(), interval, nil), nil
+	}
+	sink, err := utils.NewSampleSink(format, runenv.TestOutputsPath, fmt.Sprintf("samples-%s-%d", t.nodetp, t.tpindex))
+	if err != nil {
+		return nil, err
+	}
+	return utils.NewSampler(t.coord.Clock(), interval, sink), nil
+}
+
+// ledgerProbe samples the bitswap ledgers of this node with every other peer,
+// and feeds them to the fairness tracker of the run.
+func (t *NodeTestData) ledgerProbe(bsnode *utils.BitswapNode, fairness *fairnessTracker) utils.Probe {
+	return func() []utils.Sample {
+		receipts := t.ledgerReceipts(bsnode)
+		fairness.sample(t.coord.Clock().Now(), receipts)
+		samples := make([]utils.Sample, len(receipts))
+		for i, r := range receipts {
+			samples[i] = utils.LedgerSample{
+				Peer:      r.Peer.String(),
+				PeerID:    r.PeerID,
+				Sent:      r.Sent,
+				Recv:      r.Recv,
+				Value:     r.Value,
+				Exchanged: r.Exchanged,
+			}
+		}
+		return samples
+	}
+}
+
+// bandwidthProbe samples the traffic of this node, if its host keeps track of
+// it.
+func (t *NodeTestData) bandwidthProbe() utils.Probe {
+	if t.reporter == nil {
+		return nil
+	}
+	return utils.BandwidthProbe(t.reporter, t.peerName)
+}
+
+// peerName names a peer of the experiment by type and type index, e.g.
+// "leech:1". It returns "" for peers outside the experiment. Bitswap and the
+// sampler call it from their own goroutines, so it reads the names from the
+// last snapshot instead of peerInfos.
+func (t *TestData) peerName(id peer.ID) string {
+	names, _ := t.peerNames.Load().(map[peer.ID]string)
+	return names[id]
+}
+
+// namePeers takes a snapshot of the names of the peers in peerInfos. It must
+// be called after every change to peerInfos.
+func (t *TestData) namePeers() {
+	names := make(map[peer.ID]string, len(t.peerInfos))
+	for _, peerInfo := range t.peerInfos {
+		names[peerInfo.Addr.ID] = ledgerPeer{peerInfo.Nodetp, peerInfo.TpIndex}.String()
+	}
+	t.peerNames.Store(names)
+}
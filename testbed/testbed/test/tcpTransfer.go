@@ -21,7 +21,7 @@ func TCPTransfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	/// --- Set up
 	ctx, cancel := context.WithTimeout(context.Background(), testvars.Timeout)
 	defer cancel()
-	t, err := InitializeTest(ctx, runenv, testvars)
+	t, err := InitializeTest(ctx, runenv, testvars, NewTestgroundCoordinator(ctx, runenv))
 	if err != nil {
 		return err
 	}
@@ -40,8 +40,7 @@ func TCPTransfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	// For each file found in the test
 	for pIndex, testParams := range testvars.Permutations {
 		// Set up network (with traffic shaping)
-		if err := utils.SetupNetwork(ctx, runenv, t.nwClient, t.nodetp, t.tpindex, testParams.Latency,
-			testParams.Bandwidth, testParams.JitterPct); err != nil {
+		if err := t.setupNetwork(ctx, runenv, testParams); err != nil {
 			return fmt.Errorf("Failed to set up network: %v", err)
 		}
 
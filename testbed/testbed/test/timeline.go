@@ -0,0 +1,46 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/testground/sdk-go/runtime"
+)
+
+// arrivalLine is a block arrival as written to the timeline of a leech.
+type arrivalLine struct {
+	Run  string `json:"run"`
+	Cid  string `json:"cid"`
+	Peer string `json:"peer"`
+	Size int    `json:"size"`
+	// Nanoseconds since the fetch started.
+	At int64 `json:"at"`
+}
+
+// saveTimeline appends the block arrivals of the last fetch to
+// timeline-<type>-<index>.jsonl in the test outputs. Peers outside the
+// experiment are written under their peer ID.
+func (t *NodeTestData) saveTimeline(runenv *runtime.RunEnv, runID string) error {
+	if t.timeline == nil {
+		return nil
+	}
+	name := fmt.Sprintf("timeline-%s-%d.jsonl", t.nodetp, t.tpindex)
+	f, err := os.OpenFile(filepath.Join(runenv.TestOutputsPath, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, a := range t.timeline.Arrivals() {
+		p := t.peerName(a.Peer)
+		if p == "" {
+			p = a.Peer.String()
+		}
+		if err := enc.Encode(arrivalLine{runID, a.Cid.String(), p, a.Size, int64(a.At)}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,27 @@
+package test
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+)
+
+// newTracer creates the bitswap message tracer of this node if bitswap_trace
+// is set, or returns nil. The trace goes to bitswap-trace-<type>-<index>.jsonl
+// in the test outputs.
+func (t *TestData) newTracer(runenv *runtime.RunEnv, testvars *TestVars) (*utils.MessageTracer, error) {
+	if !testvars.BitswapTrace {
+		return nil, nil
+	}
+	self := ledgerPeer{t.nodetp, t.tpindex}
+	path := filepath.Join(runenv.TestOutputsPath, fmt.Sprintf("bitswap-trace-%s-%d.jsonl", t.nodetp, t.tpindex))
+	tracer, err := utils.NewMessageTracer(path, self.String(), t.peerName, t.coord.Clock())
+	if err != nil {
+		return nil, fmt.Errorf("Error creating bitswap trace: %w", err)
+	}
+	runenv.RecordMessage("Tracing bitswap messages to %s", path)
+	return tracer, nil
+}
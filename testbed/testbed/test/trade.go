@@ -5,17 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/testground/sdk-go/run"
 	"github.com/testground/sdk-go/runtime"
 
 	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
 )
 
 // Trade data between peers
 func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
+	ctx := context.Background()
+	return trade(ctx, runenv, NewTestgroundCoordinator(ctx, runenv))
+}
+
+func trade(ctx context.Context, runenv *runtime.RunEnv, coord Coordinator) error {
 	// Test Parameters
 	testvars, err := getEnvVars(runenv)
 	if err != nil {
@@ -24,9 +31,9 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	nodeType := runenv.StringParam("node_type")
 
 	/// --- Set up
-	ctx, cancel := context.WithTimeout(context.Background(), testvars.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, testvars.Timeout)
 	defer cancel()
-	baseT, err := InitializeTest(ctx, runenv, testvars)
+	baseT, err := InitializeTest(ctx, runenv, testvars, coord)
 	if err != nil {
 		return err
 	}
@@ -38,6 +45,17 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	transferNode := t.node
 	signalAndWaitForAll := t.signalAndWaitForAll
 
+	// Initial state of the bitswap ledgers
+	ledgers, err := newLedgerStore(runenv, ledgerPeer{t.nodetp, t.tpindex}, t.peerInfos)
+	if err != nil {
+		return err
+	}
+
+	sampler, err := t.newSampler(runenv)
+	if err != nil {
+		return err
+	}
+
 	// Start still alive process if enabled
 	t.stillAlive(runenv, testvars)
 
@@ -52,17 +70,35 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	runenv.RecordMessage("Initializing network")
 
 	// Set up network (with traffic shaping)
-	if err := utils.SetupNetwork(ctx, runenv, t.nwClient, t.nodetp, t.tpindex, testParams.Latency,
-		testParams.Bandwidth, testParams.JitterPct); err != nil {
+	if err := t.setupNetwork(ctx, runenv, testParams); err != nil {
 		return fmt.Errorf("Failed to set up network: %v", err)
 	}
 
 	runenv.RecordMessage("Network initialized")
 
+	graph, err := newTradeGraph(runenv, runenv.TestInstanceCount)
+	if err != nil {
+		return err
+	}
+
+	// Behaviour profile of this peer, and of the others to tag their metrics.
+	self := testvars.behaviourOf(t.tpindex)
+	peerBehaviours := make(map[ledgerPeer]string)
+	whitewashers := 0
+	for _, peerInfo := range t.peerInfos {
+		b := testvars.behaviourOf(peerInfo.TpIndex)
+		peerBehaviours[ledgerPeer{peerInfo.Nodetp, peerInfo.TpIndex}] = string(b)
+		if b == whitewasher {
+			whitewashers++
+		}
+	}
+	runenv.RecordMessage("Behaving as %s peer", self)
+
 	// Accounts for every file that couldn't be found.
 	var fetchFails int64
 	publishedRootCids := []cid.Cid{}
 	fetchedRootCids := []cid.Cid{}
+	fetchedFrom := []int{}
 
 	runenv.RecordMessage("Network initialized")
 
@@ -74,44 +110,24 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 
 	runenv.RecordMessage("Publishing file CIDs...")
 
-	switch t.tpindex {
-	case 0: // 0'th peer downloads from  + uploads to everyone
-
-		// publish a single file for all to download
-		var publishedCid cid.Cid
-		publishedCid, err = t.addPublishFile(ctx, 0, testParams.File, runenv, testvars)
-		if err != nil {
-			return err
-		}
-		publishedRootCids = append(publishedRootCids, publishedCid)
-
-		// grab cids to download from all peers
-		for i := 1; i < len(testvars.Permutations); i++ {
-			var fetchedCid cid.Cid
-			fetchedCid, err = t.readFile(ctx, i, runenv, testvars)
-			if err != nil {
-				return fmt.Errorf("Error fetching cid #%d: %s", i, err.Error())
-			}
-			runenv.RecordMessage(fmt.Sprintf("Successfuly fetched cid #%d: %s", i, fetchedCid))
-			fetchedRootCids = append(fetchedRootCids, fetchedCid)
-		}
-
-	default: // other peers publish one file for peer 0, and download one from 0
-
-		// publish a single file for 0 to download
+	// publish a single file for every peer we upload to
+	if len(graph.uploadsTo(t.tpindex)) > 0 {
 		publishedCid, err := t.addPublishFile(ctx, t.tpindex, testParams.File, runenv, testvars)
 		if err != nil {
 			return err
 		}
 		publishedRootCids = append(publishedRootCids, publishedCid)
+	}
 
-		// grab cid to download from i
-		fetchedCid, err := t.readFile(ctx, 0, runenv, testvars)
+	// grab cids to download from every peer we download from
+	for _, i := range graph.downloadsFrom(t.tpindex) {
+		fetchedCid, err := t.readFile(ctx, i, runenv, testvars)
 		if err != nil {
-			return err
+			return fmt.Errorf("Error fetching cid #%d: %s", i, err.Error())
 		}
-		runenv.RecordMessage(fmt.Sprintf("Successfuly fetched cid #0: %s", fetchedCid))
+		runenv.RecordMessage(fmt.Sprintf("Successfuly fetched cid #%d: %s", i, fetchedCid))
 		fetchedRootCids = append(fetchedRootCids, fetchedCid)
+		fetchedFrom = append(fetchedFrom, i)
 	}
 
 	runenv.RecordMessage("File injest complete...")
@@ -138,23 +154,38 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 
 		runenv.RecordMessage("Starting run %d / %d (%d bytes)", runNum, testvars.RunCount, testParams.File.Size())
 
-		var peersToDial []utils.PeerInfo
-		switch t.tpindex {
-		case 0: // peer 0 connects to everyone
-			for _, peerInfo := range t.peerInfos {
-				if peerInfo.TpIndex != 0 {
-					peersToDial = append(peersToDial, peerInfo)
+		// whitewashers come back as a new peer on every run after the first,
+		// which starts from the initial ledgers. Everyone learns their new
+		// peer IDs to look up their ledgers and name them in metrics.
+		whitewashed := make(map[ledgerPeer]bool)
+		if runNum > 1 && whitewashers > 0 {
+			if self == whitewasher {
+				if _, err := t.whitewash(ctx, runenv, self.hooks(testvars, t.coord.Clock())); err != nil {
+					return err
 				}
+				transferNode = t.node
 			}
-		default: // everyone connects to peer 0
-			for _, peerInfo := range t.peerInfos {
-				if peerInfo.TpIndex == 0 {
-					peersToDial = append(peersToDial, peerInfo)
-				}
+			whitewashed, err = t.updateWhitewashed(ctx, runID, self == whitewasher, whitewashers)
+			if err != nil {
+				return err
 			}
 		}
 
-		dialed, err := t.dialFn(ctx, *t.host, t.nodetp, peersToDial, testvars.MaxConnectionRate)
+		// peers only connect to the peers they trade with. Nobody knows the
+		// current peer ID of a whitewasher, so they dial the others instead.
+		var peersToDial []utils.PeerInfo
+		for _, peerInfo := range t.peerInfos {
+			if graph.tradesWith(t.tpindex, peerInfo.TpIndex) && testvars.behaviourOf(peerInfo.TpIndex) != whitewasher {
+				peersToDial = append(peersToDial, peerInfo)
+			}
+		}
+
+		var dialed []peer.AddrInfo
+		if self == whitewasher {
+			dialed, err = t.dialPartners(ctx, peersToDial)
+		} else {
+			dialed, err = t.dialFn(ctx, *t.host, t.nodetp, peersToDial, testvars.MaxConnectionRate)
+		}
 		if err != nil {
 			return err
 		}
@@ -173,50 +204,12 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 		}
 
 		// @dgrisham: set up bitswap ledgers
-		for _, peerInfo := range t.peerInfos {
+		ledgers.apply(runenv, t, bsnode, whitewashed)
 
-			numBytesSent := getInitialSendTrade(t.tpindex, peerInfo.TpIndex)
-			if numBytesSent != 0 {
-				runenv.RecordMessage("Setting sent value in ledger to %d bytes for %s %d (peer %s)", numBytesSent, peerInfo.Nodetp, peerInfo.TpIndex, peerInfo.Addr.ID.String())
-				bsnode.Bitswap.SetLedgerSentBytes(peerInfo.Addr.ID, int(numBytesSent))
-			}
-
-			numBytesRcvd := getInitialSendTrade(peerInfo.TpIndex, t.tpindex)
-			if numBytesRcvd != 0 {
-				runenv.RecordMessage("Setting received value in ledger to %d bytes for %s %d (peer %s)", numBytesRcvd, peerInfo.Nodetp, peerInfo.TpIndex, peerInfo.Addr.ID.String())
-				bsnode.Bitswap.SetLedgerReceivedBytes(peerInfo.Addr.ID, int(numBytesRcvd))
-			}
-		}
-
-		// @dgrisham start time series metric gathering functions
-		quit := make(chan bool)
-		go func() { // record bitswap metrics in the background while fetching blocks
-
-			for {
-				select {
-
-				case <-quit: // loop until signal is received
-					return
-
-				default:
-
-					for _, peerInfo := range t.peerInfos {
-						if peerInfo.Addr.ID == (*(t.host)).ID() {
-							continue
-						}
-						receipt := bsnode.Bitswap.LedgerForPeer(peerInfo.Addr.ID)
-						receiptID := fmt.Sprintf("receiptAtTime/peer:%s/sent:%v/recv:%v/value:%v/exchanged:%v", receipt.Peer, receipt.Sent, receipt.Recv, receipt.Value, receipt.Exchanged)
-						runenv.R().RecordPoint(receiptID, float64(1))
-
-						// save ledger sends in case there are more runs/files
-						setSend(t.nodetp, t.tpindex, peerInfo.Nodetp, peerInfo.TpIndex, receipt.Sent)
-						setSend(peerInfo.Nodetp, peerInfo.TpIndex, t.nodetp, t.tpindex, receipt.Sent)
-					}
-
-					time.Sleep(1 * time.Millisecond) // 1 ms between each step
-				}
-			}
-		}()
+		// Sample the ledgers and exchange counters in the background while
+		// fetching blocks
+		fairness := newFairnessTracker(peerBehaviours)
+		sampler.Start(runID, t.ledgerProbe(bsnode, fairness), utils.ExchangeProbe(t.node), t.bandwidthProbe(), utils.ResourceProbe())
 
 		// Wait for all nodes
 		err = signalAndWaitForAll("background-metric-gathering-started-" + runID)
@@ -226,6 +219,12 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 
 		/// --- Start test
 
+		// Measure the resources the node uses while exchanging data.
+		resources, err := utils.NewResourceMeter()
+		if err != nil {
+			return err
+		}
+
 		fetchResults := make([]fetchResult, len(fetchedRootCids))
 		var wg sync.WaitGroup
 		for fetchIdx, fetchCid := range fetchedRootCids { // download all cids in parallel
@@ -234,23 +233,24 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 			go func(idx int, cid cid.Cid, wg *sync.WaitGroup) {
 				defer wg.Done()
 
-				start := time.Now()
-				runenv.RecordMessage("Starting to fetch index #%d, %d / %d (%d bytes)", runNum, idx, testvars.RunCount, testParams.File.Size())
+				start := t.coord.Clock().Now()
+				runenv.RecordMessage("Starting to fetch index #%d from peer %d, %d / %d (%d bytes)", idx, fetchedFrom[idx], runNum, testvars.RunCount, testParams.File.Size())
 
 				ctxFetch, cancel := context.WithTimeout(ctx, testvars.RunTimeout/2)
 				rcvFile, err := transferNode.Fetch(ctxFetch, cid, t.peerInfos)
 
 				if err != nil { // failure
 					runenv.RecordMessage("Error fetching cid %s: %v", cid.String(), err)
-					fetchFails++
+					atomic.AddInt64(&fetchFails, 1)
 				} else { // success, save metrics
-					timeToFetch := time.Since(start)
+					timeToFetch := t.coord.Clock().Since(start)
 					fetchResults[idx] = fetchResult{
 						CID:  cid,
+						From: fetchedFrom[idx],
 						Time: timeToFetch,
 					}
 					s, _ := rcvFile.Size()
-					runenv.RecordMessage("Fetch of %d complete (%d ns)", s, timeToFetch)
+					runenv.RecordMessage("Fetch of %d from peer %d complete (%d ns)", s, fetchedFrom[idx], timeToFetch)
 				}
 				cancel()
 			}(fetchIdx, fetchCid, &wg)
@@ -264,11 +264,28 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 			return err
 		}
 
+		if err := sampler.Stop(); err != nil {
+			return fmt.Errorf("Error writing samples: %w", err)
+		}
+
+		// Keep the ledgers as they are at the end of the run, so the next
+		// run (or a later experiment) starts from them.
+		ledgers.capture(runID, t, bsnode)
+		if err := ledgers.save(runenv); err != nil {
+			return fmt.Errorf("Error saving ledger snapshot: %w", err)
+		}
+
 		/// --- Report stats
-		err = t.emitMetricsTrade(runenv, runNum, nodeType, testParams, fetchResults, tcpFetch, fetchFails, testvars.MaxConnectionRate)
+		err = t.emitMetricsTrade(runenv, runNum, nodeType, testParams, fetchResults, tcpFetch, fetchFails, testvars.MaxConnectionRate, self)
 		if err != nil {
 			return err
 		}
+		recorder := newMetricsRecorder(runenv, runNum, t.seq, t.grpseq, nodeType, testParams.Latency,
+			testParams.Bandwidth, int(testParams.File.Size()), t.nodetp, t.tpindex, testvars.MaxConnectionRate).with("behaviour", self)
+		fairness.emit(recorder)
+		if err := t.emitResources(resources, recorder); err != nil {
+			return err
+		}
 		runenv.RecordMessage("Finishing emitting metrics. Starting to clean...")
 
 		for _, fetchCid := range fetchedRootCids {
@@ -277,6 +294,9 @@ func Trade(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 			}
 		}
 	}
+	if err := sampler.Close(); err != nil {
+		return err
+	}
 	err = t.close()
 	if err != nil {
 		return err
@@ -0,0 +1,177 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/testground/sdk-go/runtime"
+)
+
+// tradeGraph describes who trades with whom in the trade test case. An edge
+// from i to j means that peer i publishes a file that peer j downloads. Peers
+// are identified by their type index.
+type tradeGraph struct {
+	n     int
+	edges map[int]map[int]bool
+}
+
+func newTradeGraph(runenv *runtime.RunEnv, n int) (*tradeGraph, error) {
+	g := &tradeGraph{n: n, edges: make(map[int]map[int]bool)}
+
+	shape := "star"
+	if runenv.IsParamSet("trade_graph") {
+		shape = runenv.StringParam("trade_graph")
+	}
+
+	switch shape {
+	case "star":
+		// Peer 0 downloads from and uploads to everyone, every other peer
+		// only trades with peer 0.
+		for i := 1; i < n; i++ {
+			g.add(0, i)
+			g.add(i, 0)
+		}
+	case "mesh":
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				g.add(i, j)
+			}
+		}
+	case "ring":
+		// Every peer trades with its two neighbours.
+		for i := 0; i < n; i++ {
+			g.add(i, (i+1)%n)
+			g.add((i+1)%n, i)
+		}
+	case "bipartite":
+		// Peers in the first half trade with every peer in the second half.
+		half := n / 2
+		for i := 0; i < half; i++ {
+			for j := half; j < n; j++ {
+				g.add(i, j)
+				g.add(j, i)
+			}
+		}
+	case "matrix":
+		if err := g.parseEdges(runenv.StringParam("trade_matrix")); err != nil {
+			return nil, err
+		}
+	case "file":
+		path := runenv.StringParam("trade_matrix_file")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(runenv.StringParam("data_dir"), path)
+		}
+		if err := g.readMatrix(path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Trade graph %s not implemented", shape)
+	}
+	return g, nil
+}
+
+func (g *tradeGraph) add(from, to int) {
+	if from == to {
+		return
+	}
+	if _, ok := g.edges[from]; !ok {
+		g.edges[from] = make(map[int]bool)
+	}
+	g.edges[from][to] = true
+}
+
+// parseEdges reads a comma-separated list of edges such as "0>1,1>0,2>0".
+func (g *tradeGraph) parseEdges(value string) error {
+	for _, edge := range strings.Split(value, ",") {
+		edge = strings.TrimSpace(edge)
+		if edge == "" {
+			continue
+		}
+		parts := strings.Split(edge, ">")
+		if len(parts) != 2 {
+			return fmt.Errorf("Invalid trade edge %s", edge)
+		}
+		from, ferr := strconv.Atoi(strings.TrimSpace(parts[0]))
+		to, terr := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if ferr != nil || terr != nil || !g.valid(from) || !g.valid(to) {
+			return fmt.Errorf("Invalid trade edge %s", edge)
+		}
+		g.add(from, to)
+	}
+	return nil
+}
+
+// readMatrix reads an adjacency matrix with one row per peer. A non-zero entry
+// in row i, column j means i publishes a file for j. Entries may be separated
+// by commas or whitespace, and lines starting with # are ignored.
+func (g *tradeGraph) readMatrix(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	row := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !g.valid(row) {
+			return fmt.Errorf("Trade matrix %s has more than %d rows", path, g.n)
+		}
+		cols := strings.FieldsFunc(line, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(cols) != g.n {
+			return fmt.Errorf("Row %d of trade matrix %s has %d columns, expected %d", row, path, len(cols), g.n)
+		}
+		for col, v := range cols {
+			if v != "0" {
+				g.add(row, col)
+			}
+		}
+		row++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if row != g.n {
+		return fmt.Errorf("Trade matrix %s has %d rows, expected %d", path, row, g.n)
+	}
+	return nil
+}
+
+func (g *tradeGraph) valid(i int) bool {
+	return i >= 0 && i < g.n
+}
+
+// uploadsTo returns the peers downloading the file published by i.
+func (g *tradeGraph) uploadsTo(i int) []int {
+	var peers []int
+	for j := range g.edges[i] {
+		peers = append(peers, j)
+	}
+	sort.Ints(peers)
+	return peers
+}
+
+// downloadsFrom returns the peers whose files i downloads.
+func (g *tradeGraph) downloadsFrom(i int) []int {
+	var peers []int
+	for j := 0; j < g.n; j++ {
+		if g.edges[j][i] {
+			peers = append(peers, j)
+		}
+	}
+	return peers
+}
+
+// tradesWith reports whether i and j exchange data in any direction.
+func (g *tradeGraph) tradesWith(i, j int) bool {
+	return g.edges[i][j] || g.edges[j][i]
+}
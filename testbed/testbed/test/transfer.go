@@ -10,17 +10,24 @@ import (
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/multiformats/go-multiaddr"
 	"github.com/testground/sdk-go/run"
 	"github.com/testground/sdk-go/runtime"
-	"github.com/testground/sdk-go/sync"
 
 	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	files "github.com/ipfs/go-ipfs-files"
 	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
 )
 
 // Transfer data from S seeds to L leeches
 func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
+	ctx := context.Background()
+	return transfer(ctx, runenv, NewTestgroundCoordinator(ctx, runenv))
+}
+
+func transfer(ctx context.Context, runenv *runtime.RunEnv, coord Coordinator) error {
 	// Test Parameters
 	testvars, err := getEnvVars(runenv)
 	if err != nil {
@@ -29,9 +36,9 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	nodeType := runenv.StringParam("node_type")
 
 	/// --- Set up
-	ctx, cancel := context.WithTimeout(context.Background(), testvars.Timeout)
+	ctx, cancel := context.WithTimeout(ctx, testvars.Timeout)
 	defer cancel()
-	baseT, err := InitializeTest(ctx, runenv, testvars)
+	baseT, err := InitializeTest(ctx, runenv, testvars, coord)
 	if err != nil {
 		return err
 	}
@@ -43,6 +50,23 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	transferNode := t.node
 	signalAndWaitForAll := t.signalAndWaitForAll
 
+	// Initial state of the bitswap ledgers
+	ledgers, err := newLedgerStore(runenv, ledgerPeer{t.nodetp, t.tpindex}, t.peerInfos)
+	if err != nil {
+		return err
+	}
+
+	sampler, err := t.newSampler(runenv)
+	if err != nil {
+		return err
+	}
+
+	// Fetches of this leech, if recorded, to be replayed later
+	workloads, err := t.newWorkloadRecorder(runenv, testvars)
+	if err != nil {
+		return err
+	}
+
 	// Start still alive process if enabled
 	t.stillAlive(runenv, testvars)
 
@@ -51,8 +75,7 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 	// For each test permutation found in the test
 	for pIndex, testParams := range testvars.Permutations {
 		// Set up network (with traffic shaping)
-		if err := utils.SetupNetwork(ctx, runenv, t.nwClient, t.nodetp, t.tpindex, testParams.Latency,
-			testParams.Bandwidth, testParams.JitterPct); err != nil {
+		if err := t.setupNetwork(ctx, runenv, testParams); err != nil {
 			return fmt.Errorf("Failed to set up network: %v", err)
 		}
 
@@ -150,50 +173,12 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 			}
 
 			// @dgrisham: set up bitswap ledgers
-			for _, peerInfo := range t.peerInfos {
-
-				numBytesSent := getInitialSend(t.nodetp, t.tpindex, peerInfo.Nodetp, peerInfo.TpIndex)
-				if numBytesSent != 0 {
-					runenv.RecordMessage("Setting sent value in ledger to %d bytes for %s %d (peer %s)", numBytesSent, peerInfo.Nodetp, peerInfo.TpIndex, peerInfo.Addr.ID.String())
-					bsnode.Bitswap.SetLedgerSentBytes(peerInfo.Addr.ID, int(numBytesSent))
-				}
-
-				numBytesRcvd := getInitialSend(peerInfo.Nodetp, peerInfo.TpIndex, t.nodetp, t.tpindex)
-				if numBytesRcvd != 0 {
-					runenv.RecordMessage("Setting received value in ledger to %d bytes for %s %d (peer %s)", numBytesRcvd, peerInfo.Nodetp, peerInfo.TpIndex, peerInfo.Addr.ID.String())
-					bsnode.Bitswap.SetLedgerReceivedBytes(peerInfo.Addr.ID, int(numBytesRcvd))
-				}
-			}
-
-			// @dgrisham start time series metric gathering functions
-			quit := make(chan bool)
-			go func() { // record bitswap metrics in the background while fetching blocks
+			ledgers.apply(runenv, t, bsnode, nil)
 
-				for {
-					select {
-
-					case <-quit: // loop until signal is received
-						return
-
-					default:
-
-						for _, peerInfo := range t.peerInfos {
-							if peerInfo.Addr.ID == (*(t.host)).ID() {
-								continue
-							}
-							receipt := bsnode.Bitswap.LedgerForPeer(peerInfo.Addr.ID)
-							receiptID := fmt.Sprintf("receiptAtTime/peer:%s/sent:%v/recv:%v/value:%v/exchanged:%v", receipt.Peer, receipt.Sent, receipt.Recv, receipt.Value, receipt.Exchanged)
-							runenv.R().RecordPoint(receiptID, float64(1))
-
-							// save ledger sends in case there are more runs/files
-							setSend(t.nodetp, t.tpindex, peerInfo.Nodetp, peerInfo.TpIndex, receipt.Sent)
-							setSend(peerInfo.Nodetp, peerInfo.TpIndex, t.nodetp, t.tpindex, receipt.Sent)
-						}
-
-						time.Sleep(1 * time.Millisecond) // 1 ms between each step
-					}
-				}
-			}()
+			// Sample the ledgers and exchange counters in the background while
+			// fetching blocks
+			fairness := newFairnessTracker(nil)
+			sampler.Start(runID, t.ledgerProbe(bsnode, fairness), utils.ExchangeProbe(t.node), t.bandwidthProbe(), utils.ResourceProbe())
 
 			// Wait for all nodes
 			err = signalAndWaitForAll("background-metric-gathering-started-" + runID)
@@ -203,47 +188,64 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 
 			/// --- Start test
 
+			// Measure the resources the node uses while exchanging data.
+			resources, err := utils.NewResourceMeter()
+			if err != nil {
+				return err
+			}
+
 			var timeToFetch time.Duration
 			if t.nodetp == utils.Leech {
-				// For each wave
-				for waveNum := 0; waveNum < testvars.NumWaves; waveNum++ {
-					// Only leecheers for that wave entitled to leech.
-					if (t.tpindex % testvars.NumWaves) == waveNum {
-						runenv.RecordMessage("Starting wave %d", waveNum)
-						// Stagger the start of the first request from each leech
-						// Note: seq starts from 1 (not 0)
-						startDelay := time.Duration(t.seq-1) * testvars.RequestStagger
-
-						runenv.RecordMessage("Starting to leech %d / %d (%d bytes)", runNum, testvars.RunCount, testParams.File.Size())
-						runenv.RecordMessage("Leech fetching data after %s delay", startDelay)
-						start := time.Now()
-						// TODO: Here we may be able to define requesting pattern. ipfs.DAG()
-						// Right now using a path.
-						ctxFetch, cancel := context.WithTimeout(ctx, testvars.RunTimeout/2)
-						// Pin Add also traverse the whole DAG
-						// err := ipfsNode.API.Pin().Add(ctxFetch, fPath)
-						rcvFile, err := transferNode.Fetch(ctxFetch, rootCid, t.peerInfos)
-						if err != nil {
-							runenv.RecordMessage("Error fetching data: %v", err)
-							leechFails++
-						} else {
-							runenv.RecordMessage("Fetch complete, proceeding")
-							err = files.WriteTo(rcvFile, "/tmp/"+strconv.Itoa(t.tpindex)+time.Now().String())
-							if err != nil {
-								cancel()
-								return err
-							}
-							timeToFetch = time.Since(start)
-							s, _ := rcvFile.Size()
-							runenv.RecordMessage("Leech fetch of %d complete (%d ns) for wave %d", s, timeToFetch, waveNum)
-						}
-						cancel()
+				runStart := t.coord.Clock().Now()
+				// Each leech works out its own arrival time, so leeches arriving
+				// late never hold back the ones that are already fetching.
+				startDelay := testvars.Arrival.startOffset(t.tpindex)
+				runenv.RecordMessage("Leech fetching data after %s delay", startDelay)
+				select {
+				case <-t.coord.Clock().After(startDelay):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+
+				runenv.RecordMessage("Starting to leech %d / %d (%d bytes)", runNum, testvars.RunCount, testParams.File.Size())
+				start := t.coord.Clock().Now()
+				if workloads != nil {
+					topology := workloadTopology(runenv, testvars, testParams)
+					if err := workloads.record(runID, topology, testParams.File, rootCid, start.Sub(runStart)); err != nil {
+						return fmt.Errorf("Error recording workload: %w", err)
 					}
-					if waveNum < testvars.NumWaves-1 {
-						runenv.RecordMessage("Waiting 5 seconds between waves for wave %d", waveNum)
-						time.Sleep(5 * time.Second)
+				}
+				if t.timeline != nil {
+					t.timeline.Start(rootCid)
+				}
+				// TODO: Here we may be able to define requesting pattern. ipfs.DAG()
+				// Right now using a path.
+				ctxFetch, cancel := context.WithTimeout(ctx, testvars.RunTimeout/2)
+				// Pin Add also traverse the whole DAG
+				// err := ipfsNode.API.Pin().Add(ctxFetch, fPath)
+				rcvFile, err := transferNode.Fetch(ctxFetch, rootCid, t.peerInfos)
+				if err != nil {
+					runenv.RecordMessage("Error fetching data: %v", err)
+					leechFails++
+				} else {
+					runenv.RecordMessage("Fetch complete, proceeding")
+					err = files.WriteTo(rcvFile, "/tmp/"+strconv.Itoa(t.tpindex)+time.Now().String())
+					if err != nil {
+						cancel()
+						return err
 					}
-					_, err = t.client.SignalAndWait(ctx, sync.State(fmt.Sprintf("leech-wave-%d", waveNum)), testvars.LeechCount)
+					timeToFetch = t.coord.Clock().Since(start)
+					s, _ := rcvFile.Size()
+					runenv.RecordMessage("Leech fetch of %d complete (%d ns)", s, timeToFetch)
+				}
+				cancel()
+				// Files are fetched lazily by some nodes, so blocks keep
+				// arriving until the file is written.
+				if t.timeline != nil {
+					t.timeline.Stop()
+				}
+				if err := t.saveTimeline(runenv, runID); err != nil {
+					return fmt.Errorf("Error saving block timeline: %w", err)
 				}
 			}
 
@@ -253,11 +255,28 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 				return err
 			}
 
+			if err := sampler.Stop(); err != nil {
+				return fmt.Errorf("Error writing samples: %w", err)
+			}
+
+			// Keep the ledgers as they are at the end of the run, so the next
+			// run (or a later experiment) starts from them.
+			ledgers.capture(runID, t, bsnode)
+			if err := ledgers.save(runenv); err != nil {
+				return fmt.Errorf("Error saving ledger snapshot: %w", err)
+			}
+
 			/// --- Report stats
 			err = t.emitMetrics(runenv, runNum, nodeType, testParams, timeToFetch, tcpFetch, leechFails, testvars.MaxConnectionRate)
 			if err != nil {
 				return err
 			}
+			recorder := newMetricsRecorder(runenv, runNum, t.seq, t.grpseq, nodeType, testParams.Latency,
+				testParams.Bandwidth, int(testParams.File.Size()), t.nodetp, t.tpindex, testvars.MaxConnectionRate)
+			fairness.emit(recorder)
+			if err := t.emitResources(resources, recorder); err != nil {
+				return err
+			}
 			runenv.RecordMessage("Finishing emitting metrics. Starting to clean...")
 
 			err = t.cleanupRun(ctx, rootCid, runenv)
@@ -270,6 +289,14 @@ func Transfer(runenv *runtime.RunEnv, initCtx *run.InitContext) error {
 			return err
 		}
 	}
+	if err := sampler.Close(); err != nil {
+		return err
+	}
+	if workloads != nil {
+		if err := workloads.close(); err != nil {
+			return fmt.Errorf("Error writing workload records: %w", err)
+		}
+	}
 	err = t.close()
 	if err != nil {
 		return err
@@ -295,7 +322,16 @@ func initializeIPFSTest(ctx context.Context, runenv *runtime.RunEnv, testvars *T
 	// Create IPFS node
 	runenv.RecordMessage("Preparing exchange for node: %v", testvars.ExchangeInterface)
 	// Set exchange Interface
-	exch, err := utils.SetExchange(ctx, testvars.ExchangeInterface)
+	timeline := utils.NewTimeline()
+	tracer, err := baseT.newTracer(runenv, testvars)
+	if err != nil {
+		return nil, err
+	}
+	hooks := []utils.MessageHooks{timeline.BitswapHooks()}
+	if tracer != nil {
+		hooks = append(hooks, tracer.Hooks())
+	}
+	exch, err := utils.SetExchange(ctx, testvars.ExchangeInterface, hooks...)
 	if err != nil {
 		return nil, err
 	}
@@ -313,11 +349,14 @@ func initializeIPFSTest(ctx context.Context, runenv *runtime.RunEnv, testvars *T
 	return &NodeTestData{
 		TestData: baseT,
 		node:     ipfsNode,
+		reporter: ipfsNode.Node.Reporter,
+		timeline: timeline,
+		tracer:   tracer,
 	}, nil
 }
 
 func initializeBitswapTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars, baseT *TestData) (*NodeTestData, error) {
-	h, err := makeHost(ctx, baseT)
+	h, reporter, err := makeHost(ctx, baseT)
 	if err != nil {
 		return nil, err
 	}
@@ -335,17 +374,34 @@ func initializeBitswapTest(ctx context.Context, runenv *runtime.RunEnv, testvars
 	if err != nil {
 		return nil, err
 	}
-	// Create a new bitswap node from the blockstore
-	bsnode, err := utils.CreateBitswapNode(ctx, h, bstore)
+	// Create a new bitswap node from the blockstore, acting out the behaviour
+	// profile of this peer, if any.
+	hooks := testvars.behaviourOf(baseT.tpindex).hooks(testvars, baseT.coord.Clock())
+	byzantine, err := newByzantine(runenv, baseT)
+	if err != nil {
+		return nil, err
+	}
+	if byzantine != nil {
+		hooks = append(hooks, byzantine.BitswapHooks())
+	}
+	tracer, err := baseT.newTracer(runenv, testvars)
+	if err != nil {
+		return nil, err
+	}
+	if tracer != nil {
+		// Trace the messages as they go on the wire, after any rewriting.
+		hooks = append(hooks, tracer.Hooks())
+	}
+	bsnode, err := baseT.createBitswapNode(ctx, h, bstore, hooks)
 	if err != nil {
 		return nil, err
 	}
 
-	return &NodeTestData{baseT, bsnode, &h}, nil
+	return &NodeTestData{baseT, bsnode, &h, reporter, bsnode.Timeline(), tracer}, nil
 }
 
 func initializeGraphsyncTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars, baseT *TestData) (*NodeTestData, error) {
-	h, err := makeHost(ctx, baseT)
+	h, reporter, err := makeHost(ctx, baseT)
 	if err != nil {
 		return nil, err
 	}
@@ -365,12 +421,20 @@ func initializeGraphsyncTest(ctx context.Context, runenv *runtime.RunEnv, testva
 
 	// Create a new bitswap node from the blockstore
 	numSeeds := runenv.TestInstanceCount - (testvars.LeechCount + testvars.PassiveCount)
-	bsnode, err := utils.CreateGraphsyncNode(ctx, h, bstore, numSeeds)
+	var hooks []utils.GraphsyncMessageHooks
+	byzantine, err := newByzantine(runenv, baseT)
+	if err != nil {
+		return nil, err
+	}
+	if byzantine != nil {
+		hooks = append(hooks, byzantine.GraphsyncHooks())
+	}
+	bsnode, err := utils.CreateGraphsyncNode(ctx, h, bstore, numSeeds, hooks...)
 	if err != nil {
 		return nil, err
 	}
 
-	return &NodeTestData{baseT, bsnode, &h}, nil
+	return &NodeTestData{baseT, bsnode, &h, reporter, bsnode.Timeline(), nil}, nil
 }
 
 func initializeLibp2pHTTPTest(ctx context.Context, runenv *runtime.RunEnv, testvars *TestVars, baseT *TestData) (*NodeTestData, error) {
@@ -386,7 +450,7 @@ func initializeLibp2pHTTPTest(ctx context.Context, runenv *runtime.RunEnv, testv
 		return nil, errors.New("libp2p HTTP transfer does NOT support passive peers")
 	}
 
-	h, err := makeHost(ctx, baseT)
+	h, reporter, err := makeHost(ctx, baseT)
 	if err != nil {
 		return nil, err
 	}
@@ -401,6 +465,7 @@ func initializeLibp2pHTTPTest(ctx context.Context, runenv *runtime.RunEnv, testv
 		TestData: baseT,
 		node:     libp2pHttpN,
 		host:     &h,
+		reporter: reporter,
 	}, nil
 }
 
@@ -417,13 +482,13 @@ func initializeHTTPTest(ctx context.Context, runenv *runtime.RunEnv, testvars *T
 		return nil, errors.New("http transfer does NOT support passive peers")
 	}
 
-	h, err := makeHost(ctx, baseT)
+	h, reporter, err := makeHost(ctx, baseT)
 	if err != nil {
 		return nil, err
 	}
 	runenv.RecordMessage("I am %s with addrs: %v", h.ID(), h.Addrs())
 
-	httpN, err := utils.CreateHTTPNode(ctx, h, baseT.nodetp)
+	httpN, err := utils.CreateHTTPNode(ctx, h, baseT.nodetp, utils.HTTPPort)
 	if err != nil {
 		return nil, err
 	}
@@ -432,6 +497,7 @@ func initializeHTTPTest(ctx context.Context, runenv *runtime.RunEnv, testvars *T
 		TestData: baseT,
 		node:     httpN,
 		host:     &h,
+		reporter: reporter,
 	}, nil
 }
 
@@ -448,7 +514,7 @@ func initializeRawLibp2pTest(ctx context.Context, runenv *runtime.RunEnv, testva
 		return nil, errors.New("libp2P transfer does NOT support passive peers")
 	}
 
-	h, err := makeHost(ctx, baseT)
+	h, reporter, err := makeHost(ctx, baseT)
 	if err != nil {
 		return nil, err
 	}
@@ -463,15 +529,49 @@ func initializeRawLibp2pTest(ctx context.Context, runenv *runtime.RunEnv, testva
 		TestData: baseT,
 		node:     rawLibp2pN,
 		host:     &h,
+		reporter: reporter,
 	}, nil
 }
 
-func makeHost(ctx context.Context, baseT *TestData) (host.Host, error) {
+func makeHost(ctx context.Context, baseT *TestData) (host.Host, *metrics.BandwidthCounter, error) {
 	// Create libp2p node
 	privKey, err := crypto.UnmarshalPrivateKey(baseT.nConfig.PrivKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return libp2p.New(ctx, libp2p.Identity(privKey), libp2p.ListenAddrs(baseT.nConfig.AddrInfo.Addrs...))
+	return baseT.newHost(ctx, privKey, baseT.nConfig.AddrInfo.Addrs)
+}
+
+// newHost creates a libp2p host that keeps track of its bandwidth. Hosts of
+// coordinators that provide them, like the mocknet of in-process runs, don't
+// report their bandwidth.
+func (t *TestData) newHost(ctx context.Context, privKey crypto.PrivKey, addrs []multiaddr.Multiaddr) (host.Host, *metrics.BandwidthCounter, error) {
+	if hp, ok := t.coord.(hostProvider); ok {
+		h, err := hp.newHost(privKey, addrs[0])
+		return h, nil, err
+	}
+	reporter := metrics.NewBandwidthCounter()
+	h, err := libp2p.New(ctx, libp2p.Identity(privKey), libp2p.ListenAddrs(addrs...), libp2p.BandwidthReporter(reporter))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, reporter, nil
+}
+
+// createBitswapNode creates a bitswap node that runs on the clock of the
+// instance. If the coordinator simulates the links between the nodes, the
+// messages go through them after every other hook.
+func (t *TestData) createBitswapNode(ctx context.Context, h host.Host, bstore blockstore.Blockstore, hooks []utils.MessageHooks) (*utils.BitswapNode, error) {
+	if ls, ok := t.coord.(linkSimulator); ok {
+		if links := ls.simLinks(); links != nil {
+			hooks = append(hooks, links.Hooks(h.ID()))
+		}
+	}
+	bsnode, err := utils.CreateBitswapNode(ctx, h, bstore, hooks...)
+	if err != nil {
+		return nil, err
+	}
+	bsnode.Timeline().SetClock(t.coord.Clock())
+	return bsnode, nil
 }
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/testground/sdk-go/runtime"
+
+	"github.com/protocol/beyond-bitswap/testbed/testbed/utils"
+	"github.com/protocol/beyond-bitswap/testbed/testbed/workload"
+)
+
+// workloadRecorder records the fetches of a leech to
+// workload-<type>-<index>.jsonl in its outputs, one workload.Record per line.
+type workloadRecorder struct {
+	f       *os.File
+	enc     *json.Encoder
+	leech   int
+	dataDir string
+}
+
+// newWorkloadRecorder creates the workload recorder of this node if
+// record_workload is set and it is a leech, or returns nil.
+func (t *TestData) newWorkloadRecorder(runenv *runtime.RunEnv, testvars *TestVars) (*workloadRecorder, error) {
+	if !testvars.RecordWorkload || t.nodetp != utils.Leech {
+		return nil, nil
+	}
+	name := fmt.Sprintf("%s%s-%d.jsonl", workload.RecordsPrefix, t.nodetp, t.tpindex)
+	f, err := os.Create(filepath.Join(runenv.TestOutputsPath, name))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating workload records: %w", err)
+	}
+	runenv.RecordMessage("Recording fetches to %s", name)
+	return &workloadRecorder{f, json.NewEncoder(f), t.tpindex, runenv.StringParam("data_dir")}, nil
+}
+
+// record records a fetch of file f, with root c, offset after the start of
+// the run.
+func (r *workloadRecorder) record(run string, topology workload.Topology, f utils.TestFile, c cid.Cid, offset time.Duration) error {
+	item, err := workloadItem(f, c, r.dataDir)
+	if err != nil {
+		return err
+	}
+	offsetMS := float64(offset) / float64(time.Millisecond)
+	return r.enc.Encode(workload.Record{Run: run, Topology: topology, Leech: r.leech, Item: item, OffsetMS: offsetMS})
+}
+
+func (r *workloadRecorder) close() error {
+	return r.f.Close()
+}
+
+func workloadTopology(runenv *runtime.RunEnv, testvars *TestVars, p TestPermutation) workload.Topology {
+	return workload.Topology{
+		Seeds:       runenv.TestInstanceCount - testvars.LeechCount - testvars.PassiveCount,
+		Leeches:     testvars.LeechCount,
+		Passives:    testvars.PassiveCount,
+		LatencyMS:   p.Latency.Milliseconds(),
+		BandwidthMB: p.Bandwidth,
+		JitterPct:   p.JitterPct,
+	}
+}
+
+// workloadItem describes a test file so a replay can generate it again. Paths
+// inside dataDir are kept relative to it, so the replay can use a data_dir of
+// its own.
+func workloadItem(f utils.TestFile, c cid.Cid, dataDir string) (workload.Item, error) {
+	item := workload.Item{CID: c.String(), Size: f.Size()}
+	switch f := f.(type) {
+	case *utils.RandFile:
+		seed := f.Seed()
+		item.Seed = &seed
+	case *utils.PathFile:
+		item.Path = f.Path
+		if rel, err := filepath.Rel(dataDir, f.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			item.Path = rel
+		}
+	default:
+		return item, fmt.Errorf("Files of type %T can't be recorded", f)
+	}
+	return item, nil
+}
+
+// itemFile returns the test file of a workload item.
+func itemFile(item workload.Item, dataDir string) (utils.TestFile, error) {
+	if item.Seed != nil {
+		return utils.NewRandFile(item.Size, *item.Seed), nil
+	}
+	path := item.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dataDir, path)
+	}
+	f, err := utils.NewPathFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading item %s: %w", item.CID, err)
+	}
+	return f, nil
+}
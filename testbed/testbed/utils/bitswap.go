@@ -2,12 +2,16 @@ package utils
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	bs "github.com/ipfs/go-bitswap"
 	bsnet "github.com/ipfs/go-bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
@@ -23,6 +27,7 @@ import (
 	unixfile "github.com/ipfs/go-unixfs/file"
 	"github.com/ipfs/go-unixfs/importer/helpers"
 	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
@@ -46,6 +51,16 @@ func (nt NodeType) String() string {
 	return [...]string{"Seed", "Leech", "Passive", "Active"}[nt]
 }
 
+// ParseNodeType returns the node type with the given name (case insensitive).
+func ParseNodeType(s string) (NodeType, error) {
+	for _, nt := range []NodeType{Seed, Leech, Passive, Active} {
+		if strings.EqualFold(nt.String(), strings.TrimSpace(s)) {
+			return nt, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown node type %s", s)
+}
+
 // Adapted from the netflix/p2plab repo under an Apache-2 license.
 // Original source code located at https://github.com/Netflix/p2plab/blob/master/peer/peer.go
 type BitswapNode struct {
@@ -53,16 +68,57 @@ type BitswapNode struct {
 	blockStore blockstore.Blockstore
 	dserv      ipld.DAGService
 	h          host.Host
+	verifier   *BlockVerifier
+	timeline   *Timeline
+	dropped    *droppedBlocks
+}
+
+// droppedBlocks keeps track of the blocks the hooks of a bitswap node dropped
+// from the messages bitswap sent, which bitswap accounts for as sent anyway.
+type droppedBlocks struct {
+	mu     sync.Mutex
+	blocks uint64
+	bytes  uint64
+	// Bytes dropped for every peer since its ledger was last set.
+	toPeer map[peer.ID]uint64
+}
+
+func (d *droppedBlocks) hooks() MessageHooks {
+	return MessageHooks{Dropped: func(p peer.ID, blks []blocks.Block) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for _, b := range blks {
+			size := uint64(len(b.RawData()))
+			d.blocks++
+			d.bytes += size
+			d.toPeer[p] += size
+		}
+	}}
 }
 
 func (n *BitswapNode) Close() error {
 	return n.Bitswap.Close()
 }
 
-func CreateBlockstore(ctx context.Context, dStore ds.Batching) (blockstore.Blockstore, error) {
-	return blockstore.CachedBlockstore(ctx,
-		blockstore.NewBlockstore(dStore),
+// CreateBlockstore creates a cached blockstore on top of the datastore, keeping
+// track of its operations and cache misses.
+func CreateBlockstore(ctx context.Context, dStore ds.Batching) (*InstrumentedBlockstore, error) {
+	stats := NewBlockstoreStats()
+	bstore, err := blockstore.CachedBlockstore(ctx,
+		blockstore.NewBlockstore(CountLookups(dStore, stats)),
 		blockstore.DefaultCacheOpts())
+	if err != nil {
+		return nil, err
+	}
+	return InstrumentBlockstore(bstore, stats), nil
+}
+
+// emitBlockstoreMetrics records the blockstore operations of a node, if its
+// blockstore keeps track of them.
+func emitBlockstoreMetrics(bstore blockstore.Blockstore, recorder MetricsRecorder) {
+	if ib, ok := bstore.(*InstrumentedBlockstore); ok {
+		ib.Stats().EmitMetrics(recorder)
+	}
 }
 
 // CreateDatastore creates a data store to use for the transfer.
@@ -112,16 +168,22 @@ func ClearBlockstore(ctx context.Context, bstore blockstore.Blockstore) error {
 	return g.Wait()
 }
 
-func CreateBitswapNode(ctx context.Context, h host.Host, bstore blockstore.Blockstore) (*BitswapNode, error) {
+// CreateBitswapNode creates a bitswap node on top of the given host and
+// blockstore. Message hooks, if any, are run on every message the node sends
+// and receives.
+func CreateBitswapNode(ctx context.Context, h host.Host, bstore blockstore.Blockstore, hooks ...MessageHooks) (*BitswapNode, error) {
 	routing, err := nilrouting.ConstructNilRouting(ctx, nil, nil, nil)
 	if err != nil {
 		return nil, err
 	}
-	net := bsnet.NewFromIpfsHost(h, routing)
+	verifier := NewBlockVerifier()
+	timeline := NewTimeline()
+	dropped := &droppedBlocks{toPeer: make(map[peer.ID]uint64)}
+	net := WrapBitswapNetwork(bsnet.NewFromIpfsHost(h, routing), append(hooks, verifier.BitswapHooks(), timeline.BitswapHooks(), dropped.hooks())...)
 	bitswap := bs.New(ctx, net, bstore).(*bs.Bitswap)
 	bserv := blockservice.New(bstore, bitswap)
 	dserv := merkledag.NewDAGService(bserv)
-	return &BitswapNode{bitswap, bstore, dserv, h}, nil
+	return &BitswapNode{bitswap, bstore, dserv, h, verifier, timeline, dropped}, nil
 }
 
 func (n *BitswapNode) Add(ctx context.Context, fileNode files.Node) (cid.Cid, error) {
@@ -148,8 +210,66 @@ func (n *BitswapNode) ClearDatastore(ctx context.Context, _ cid.Cid) error {
 	return ClearBlockstore(ctx, n.blockStore)
 }
 
-func (n *BitswapNode) EmitMetrics(recorder MetricsRecorder) error {
+// stat returns the bitswap stats of the node. The blocks its hooks dropped
+// don't count as sent.
+func (n *BitswapNode) stat() (*bs.Stat, error) {
 	stats, err := n.Bitswap.Stat()
+	if err != nil {
+		return nil, err
+	}
+	n.dropped.mu.Lock()
+	defer n.dropped.mu.Unlock()
+	// Bitswap counts a message once it is sent, so it may not have counted the
+	// last blocks dropped yet.
+	stats.BlocksSent = subtract(stats.BlocksSent, n.dropped.blocks)
+	stats.DataSent = subtract(stats.DataSent, n.dropped.bytes)
+	return stats, nil
+}
+
+func subtract(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+// LedgerReceipt is the state of the bitswap ledger of a node with a peer.
+type LedgerReceipt struct {
+	Sent      uint64
+	Recv      uint64
+	Value     float64
+	Exchanged uint64
+}
+
+// LedgerForPeer returns the ledger of the node with peer p. The bytes its
+// hooks dropped don't count as sent.
+func (n *BitswapNode) LedgerForPeer(p peer.ID) LedgerReceipt {
+	r := n.Bitswap.LedgerForPeer(p)
+	receipt := LedgerReceipt{Sent: r.Sent, Recv: r.Recv, Value: r.Value, Exchanged: r.Exchanged}
+
+	n.dropped.mu.Lock()
+	dropped := n.dropped.toPeer[p]
+	n.dropped.mu.Unlock()
+	if dropped == 0 {
+		return receipt
+	}
+	receipt.Sent = subtract(receipt.Sent, dropped)
+	// The debt ratio of bitswap, out of the bytes really sent.
+	receipt.Value = float64(receipt.Sent) / float64(receipt.Recv+1)
+	return receipt
+}
+
+// SetLedgerSentBytes sets the bytes the ledger of the node with peer p counts
+// as sent.
+func (n *BitswapNode) SetLedgerSentBytes(p peer.ID, sent int) {
+	n.dropped.mu.Lock()
+	defer n.dropped.mu.Unlock()
+	n.Bitswap.SetLedgerSentBytes(p, sent)
+	delete(n.dropped.toPeer, p)
+}
+
+func (n *BitswapNode) EmitMetrics(recorder MetricsRecorder) error {
+	stats, err := n.stat()
 	if err != nil {
 		return err
 	}
@@ -161,9 +281,30 @@ func (n *BitswapNode) EmitMetrics(recorder MetricsRecorder) error {
 	recorder.Record("blks_sent", float64(stats.BlocksSent))
 	recorder.Record("blks_rcvd", float64(stats.BlocksReceived))
 	recorder.Record("dup_blks_rcvd", float64(stats.DupBlksReceived))
+	n.verifier.EmitMetrics(recorder)
+	emitBlockstoreMetrics(n.blockStore, recorder)
 	return err
 }
 
+func (n *BitswapNode) ExchangeStats() (ExchangeSample, error) {
+	stats, err := n.stat()
+	if err != nil {
+		return ExchangeSample{}, err
+	}
+	return bitswapSample(stats), nil
+}
+
+func bitswapSample(stats *bs.Stat) ExchangeSample {
+	return ExchangeSample{
+		MessagesReceived: stats.MessagesReceived,
+		DataSent:         stats.DataSent,
+		DataReceived:     stats.DataReceived,
+		BlocksSent:       stats.BlocksSent,
+		BlocksReceived:   stats.BlocksReceived,
+		DupBlksReceived:  stats.DupBlksReceived,
+	}
+}
+
 func (n *BitswapNode) Fetch(ctx context.Context, c cid.Cid, _ []PeerInfo) (files.Node, error) {
 	err := merkledag.FetchGraph(ctx, c, n.dserv)
 	if err != nil {
@@ -185,8 +326,17 @@ func (n *BitswapNode) Host() host.Host {
 	return n.h
 }
 
+func (n *BitswapNode) Blockstore() blockstore.Blockstore {
+	return n.blockStore
+}
+
+// Timeline returns the arrival timeline of the blocks the node fetches.
+func (n *BitswapNode) Timeline() *Timeline {
+	return n.timeline
+}
+
 func (n *BitswapNode) EmitKeepAlive(recorder MessageRecorder) error {
-	stats, err := n.Bitswap.Stat()
+	stats, err := n.stat()
 	if err != nil {
 		return err
 	}
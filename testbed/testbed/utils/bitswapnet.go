@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"context"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	bsnet "github.com/ipfs/go-bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MessageHook inspects or rewrites a bitswap message exchanged with peer p.
+// Returning nil drops the message.
+type MessageHook func(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage
+
+// MessageHooks are run on the messages a bitswap node sends (Outgoing) and
+// receives (Incoming). SendFailed is told about the messages that went through
+// every outgoing hook but couldn't be sent, and Dropped about the blocks the
+// outgoing hooks took out of the messages bitswap sent. Any of them may be nil.
+type MessageHooks struct {
+	Outgoing   MessageHook
+	Incoming   MessageHook
+	SendFailed func(p peer.ID, msg bsmsg.BitSwapMessage)
+	Dropped    func(p peer.ID, blks []blocks.Block)
+}
+
+// hookedNetwork runs message hooks on top of a bitswap network.
+type hookedNetwork struct {
+	bsnet.BitSwapNetwork
+	hooks []MessageHooks
+}
+
+// WrapBitswapNetwork returns a network that runs the given hooks, in order, on
+// every message before it is sent or delivered to bitswap.
+func WrapBitswapNetwork(net bsnet.BitSwapNetwork, hooks ...MessageHooks) bsnet.BitSwapNetwork {
+	if len(hooks) == 0 {
+		return net
+	}
+	return &hookedNetwork{net, hooks}
+}
+
+func (n *hookedNetwork) outgoing(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	for _, h := range n.hooks {
+		if h.Outgoing == nil {
+			continue
+		}
+		if msg = h.Outgoing(ctx, p, msg); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
+
+func (n *hookedNetwork) incoming(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+	for _, h := range n.hooks {
+		if h.Incoming == nil {
+			continue
+		}
+		if msg = h.Incoming(ctx, p, msg); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
+
+// sendFailed tells the hooks a message that went through them wasn't sent.
+func (n *hookedNetwork) sendFailed(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, h := range n.hooks {
+		if h.SendFailed != nil {
+			h.SendFailed(p, msg)
+		}
+	}
+}
+
+// dropped tells the hooks about the blocks of msg that aren't in the message
+// sent in its place. Bitswap sends the blocks it serves with SendMessage, and
+// accounts for all of them as sent, in its counters and in its ledger with p.
+func (n *hookedNetwork) dropped(p peer.ID, msg, sent bsmsg.BitSwapMessage) {
+	if msg == sent || len(msg.Blocks()) == 0 {
+		return
+	}
+	kept := make(map[cid.Cid]bool)
+	if sent != nil {
+		for _, b := range sent.Blocks() {
+			kept[b.Cid()] = true
+		}
+	}
+	var dropped []blocks.Block
+	for _, b := range msg.Blocks() {
+		if !kept[b.Cid()] {
+			dropped = append(dropped, b)
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	for _, h := range n.hooks {
+		if h.Dropped != nil {
+			h.Dropped(p, dropped)
+		}
+	}
+}
+
+func (n *hookedNetwork) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	out := n.outgoing(ctx, p, msg)
+	if out == nil {
+		n.dropped(p, msg, nil)
+		return nil
+	}
+	if err := n.BitSwapNetwork.SendMessage(ctx, p, out); err != nil {
+		n.sendFailed(p, out)
+		return err
+	}
+	n.dropped(p, msg, out)
+	return nil
+}
+
+func (n *hookedNetwork) NewMessageSender(ctx context.Context, p peer.ID) (bsnet.MessageSender, error) {
+	sender, err := n.BitSwapNetwork.NewMessageSender(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedSender{sender, n, p}, nil
+}
+
+func (n *hookedNetwork) SetDelegate(r bsnet.Receiver) {
+	n.BitSwapNetwork.SetDelegate(&hookedReceiver{r, n})
+}
+
+type hookedSender struct {
+	bsnet.MessageSender
+	n *hookedNetwork
+	p peer.ID
+}
+
+func (s *hookedSender) SendMsg(ctx context.Context, msg bsmsg.BitSwapMessage) error {
+	if msg = s.n.outgoing(ctx, s.p, msg); msg == nil {
+		return nil
+	}
+	err := s.MessageSender.SendMsg(ctx, msg)
+	if err != nil {
+		s.n.sendFailed(s.p, msg)
+	}
+	return err
+}
+
+type hookedReceiver struct {
+	bsnet.Receiver
+	n *hookedNetwork
+}
+
+func (r *hookedReceiver) ReceiveMessage(ctx context.Context, sender peer.ID, incoming bsmsg.BitSwapMessage) {
+	if incoming = r.n.incoming(ctx, sender, incoming); incoming == nil {
+		return
+	}
+	r.Receiver.ReceiveMessage(ctx, sender, incoming)
+}
+
+// FilterMessage copies a bitswap message keeping its wantlist, and passing its
+// blocks and block presences through the given functions. keepBlock may
+// replace a block, or return nil to drop it. presence gets whether the sender
+// claims to have the block and returns whether to keep the presence and what
+// to claim instead. Nil functions keep everything as is.
+func FilterMessage(msg bsmsg.BitSwapMessage, keepBlock func(blocks.Block) blocks.Block,
+	presence func(c cid.Cid, have bool) (keep bool, claimHave bool)) bsmsg.BitSwapMessage {
+	out := bsmsg.New(msg.Full())
+	for _, e := range msg.Wantlist() {
+		if e.Cancel {
+			out.Cancel(e.Cid)
+		} else {
+			out.AddEntry(e.Cid, e.Priority, e.WantType, e.SendDontHave)
+		}
+	}
+	for _, b := range msg.Blocks() {
+		if keepBlock != nil {
+			if b = keepBlock(b); b == nil {
+				continue
+			}
+		}
+		out.AddBlock(b)
+	}
+	addPresence := func(c cid.Cid, have bool) {
+		if presence != nil {
+			var keep bool
+			if keep, have = presence(c, have); !keep {
+				return
+			}
+		}
+		if have {
+			out.AddHave(c)
+		} else {
+			out.AddDontHave(c)
+		}
+	}
+	for _, c := range msg.Haves() {
+		addPresence(c, true)
+	}
+	for _, c := range msg.DontHaves() {
+		addPresence(c, false)
+	}
+	out.SetPendingBytes(msg.PendingBytes())
+	return out
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// Blockstore operations, as named in the metrics.
+const (
+	bstoreGet     = "get"
+	bstorePut     = "put"
+	bstoreHas     = "has"
+	bstoreGetSize = "get_size"
+	bstoreDelete  = "delete"
+)
+
+var bstoreOps = []string{bstoreGet, bstorePut, bstoreHas, bstoreGetSize, bstoreDelete}
+
+type opStats struct {
+	count uint64
+	time  time.Duration
+}
+
+// BlockstoreStats counts and times the operations on a blockstore, and the
+// lookups that miss its cache and reach the datastore.
+type BlockstoreStats struct {
+	mu       sync.Mutex
+	ops      map[string]opStats
+	dsLookup uint64
+}
+
+func NewBlockstoreStats() *BlockstoreStats {
+	return &BlockstoreStats{ops: make(map[string]opStats)}
+}
+
+func (s *BlockstoreStats) done(op string, n int, start time.Time) {
+	d := time.Since(start)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.ops[op]
+	st.count += uint64(n)
+	st.time += d
+	s.ops[op] = st
+}
+
+func (s *BlockstoreStats) lookup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dsLookup++
+}
+
+// EmitMetrics records the number of operations of each kind since the last
+// call and the total time spent on them, and starts over. The cache hit ratio
+// is the fraction of block lookups answered without reading the datastore.
+// Get, Has and GetSize look up their block, and Put and PutMany look up every
+// block they store to skip the ones already there.
+func (s *BlockstoreStats) EmitMetrics(recorder MetricsRecorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, op := range bstoreOps {
+		st := s.ops[op]
+		recorder.Record("bstore_"+op+"_count", float64(st.count))
+		recorder.Record("bstore_"+op+"_time", float64(st.time))
+	}
+	lookups := s.ops[bstoreGet].count + s.ops[bstoreHas].count + s.ops[bstoreGetSize].count + s.ops[bstorePut].count
+	if lookups > 0 {
+		hits := float64(lookups) - float64(s.dsLookup)
+		recorder.Record("bstore_cache_hit_ratio", hits/float64(lookups))
+	}
+	s.ops = make(map[string]opStats)
+	s.dsLookup = 0
+}
+
+// InstrumentedBlockstore counts and times the operations on a blockstore.
+type InstrumentedBlockstore struct {
+	blockstore.Blockstore
+	stats *BlockstoreStats
+}
+
+// InstrumentBlockstore wraps bs so its operations are accounted for in stats.
+func InstrumentBlockstore(bs blockstore.Blockstore, stats *BlockstoreStats) *InstrumentedBlockstore {
+	return &InstrumentedBlockstore{bs, stats}
+}
+
+// Stats returns the statistics of the blockstore.
+func (b *InstrumentedBlockstore) Stats() *BlockstoreStats {
+	return b.stats
+}
+
+func (b *InstrumentedBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	defer b.stats.done(bstoreGet, 1, time.Now())
+	return b.Blockstore.Get(c)
+}
+
+func (b *InstrumentedBlockstore) Put(blk blocks.Block) error {
+	defer b.stats.done(bstorePut, 1, time.Now())
+	return b.Blockstore.Put(blk)
+}
+
+func (b *InstrumentedBlockstore) PutMany(blks []blocks.Block) error {
+	defer b.stats.done(bstorePut, len(blks), time.Now())
+	return b.Blockstore.PutMany(blks)
+}
+
+func (b *InstrumentedBlockstore) Has(c cid.Cid) (bool, error) {
+	defer b.stats.done(bstoreHas, 1, time.Now())
+	return b.Blockstore.Has(c)
+}
+
+func (b *InstrumentedBlockstore) GetSize(c cid.Cid) (int, error) {
+	defer b.stats.done(bstoreGetSize, 1, time.Now())
+	return b.Blockstore.GetSize(c)
+}
+
+func (b *InstrumentedBlockstore) DeleteBlock(c cid.Cid) error {
+	defer b.stats.done(bstoreDelete, 1, time.Now())
+	return b.Blockstore.DeleteBlock(c)
+}
+
+// lookupCounter counts the block lookups that reach a datastore.
+type lookupCounter struct {
+	ds.Batching
+	stats *BlockstoreStats
+}
+
+// CountLookups wraps a datastore so the block lookups reaching it are counted
+// as cache misses in stats.
+func CountLookups(d ds.Batching, stats *BlockstoreStats) ds.Batching {
+	return &lookupCounter{d, stats}
+}
+
+func (d *lookupCounter) count(k ds.Key) {
+	if blockstore.BlockPrefix.IsAncestorOf(k) {
+		d.stats.lookup()
+	}
+}
+
+func (d *lookupCounter) Get(k ds.Key) ([]byte, error) {
+	d.count(k)
+	return d.Batching.Get(k)
+}
+
+func (d *lookupCounter) Has(k ds.Key) (bool, error) {
+	d.count(k)
+	return d.Batching.Has(k)
+}
+
+func (d *lookupCounter) GetSize(k ds.Key) (int, error) {
+	d.count(k)
+	return d.Batching.GetSize(k)
+}
+
+var _ blockstore.Blockstore = &InstrumentedBlockstore{}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	blocks "github.com/ipfs/go-block-format"
+	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// ByzantineMode is the way a byzantine seed misbehaves when serving a block.
+type ByzantineMode string
+
+const (
+	// Sends the block with content that doesn't match its CID. Receivers
+	// derive the CIDs of the blocks they get from their content, so they see
+	// a block nobody asked for instead.
+	CorruptBlocks ByzantineMode = "corrupt"
+	// Sends a random block nobody asked for along with the block.
+	UnrequestedBlocks ByzantineMode = "unrequested"
+	// Never sends the block. Bitswap nodes don't count it as sent.
+	WithheldBlocks ByzantineMode = "withhold"
+)
+
+// Byzantine misbehaves on a fraction of the blocks a node sends.
+type Byzantine struct {
+	mode ByzantineMode
+	rate float64
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewByzantine returns a byzantine behaviour that acts on every sent block
+// with probability rate (between 0 and 1).
+func NewByzantine(mode ByzantineMode, rate float64, seed int64) (*Byzantine, error) {
+	switch mode {
+	case CorruptBlocks, UnrequestedBlocks, WithheldBlocks:
+	default:
+		return nil, fmt.Errorf("Byzantine mode %s not implemented", mode)
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("Byzantine rate must be between 0 and 1")
+	}
+	return &Byzantine{mode: mode, rate: rate, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+func (b *Byzantine) pick() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rng.Float64() < b.rate
+}
+
+func (b *Byzantine) garbage(size int) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := make([]byte, size)
+	b.rng.Read(data)
+	return data
+}
+
+// mangle returns the blocks to send in place of the given ones, and whether
+// any of them were withheld.
+func (b *Byzantine) mangle(in []blocks.Block) ([]blocks.Block, bool) {
+	var out []blocks.Block
+	withheld := false
+	for _, blk := range in {
+		if !b.pick() {
+			out = append(out, blk)
+			continue
+		}
+		switch b.mode {
+		case CorruptBlocks:
+			bad, err := blocks.NewBlockWithCid(b.garbage(len(blk.RawData())), blk.Cid())
+			if err != nil {
+				out = append(out, blk)
+				continue
+			}
+			out = append(out, bad)
+		case UnrequestedBlocks:
+			out = append(out, blk, blocks.NewBlock(b.garbage(len(blk.RawData()))))
+		case WithheldBlocks:
+			withheld = true
+		}
+	}
+	return out, withheld
+}
+
+// BitswapHooks returns the hooks that make a bitswap node misbehave.
+func (b *Byzantine) BitswapHooks() MessageHooks {
+	return MessageHooks{Outgoing: func(_ context.Context, _ peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+		if len(msg.Blocks()) == 0 {
+			return msg
+		}
+		mangled, _ := b.mangle(msg.Blocks())
+		out := FilterMessage(msg, func(blocks.Block) blocks.Block { return nil }, nil)
+		for _, blk := range mangled {
+			out.AddBlock(blk)
+		}
+		if out.Empty() {
+			return nil
+		}
+		return out
+	}}
+}
+
+// GraphsyncHooks returns the hooks that make a graphsync node misbehave.
+// Graphsync responses announce every block they carry, so withholding a block
+// withholds the whole message.
+func (b *Byzantine) GraphsyncHooks() GraphsyncMessageHooks {
+	return GraphsyncMessageHooks{Outgoing: func(_ context.Context, _ peer.ID, msg gsmsg.GraphSyncMessage) gsmsg.GraphSyncMessage {
+		if len(msg.Blocks()) == 0 {
+			return msg
+		}
+		mangled, withheld := b.mangle(msg.Blocks())
+		if withheld {
+			return nil
+		}
+		out := gsmsg.New()
+		for _, req := range msg.Requests() {
+			out.AddRequest(req)
+		}
+		for _, resp := range msg.Responses() {
+			out.AddResponse(resp)
+		}
+		for _, blk := range mangled {
+			out.AddBlock(blk)
+		}
+		return out
+	}}
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// TestWithheldBlocks checks that a bitswap seed withholding every block sends
+// none, and doesn't count them as sent.
+func TestWithheldBlocks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	byzantine, err := NewByzantine(WithheldBlocks, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mn := mocknet.New(ctx)
+	seed, err := CreateBitswapNode(ctx, newMockHost(t, mn), newBlockstore(ctx, t), byzantine.BitswapHooks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seed.Close()
+	leech, err := CreateBitswapNode(ctx, newMockHost(t, mn), newBlockstore(ctx, t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leech.Close()
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewRandFile(1024*1024, 1).GenerateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := seed.Add(ctx, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, 3*time.Second)
+	defer cancelFetch()
+	if _, err := leech.Fetch(fetchCtx, root, nil); err == nil {
+		t.Fatal("Leech fetched withheld blocks")
+	}
+
+	metrics := metricsMap{}
+	if err := seed.EmitMetrics(metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics["data_sent"] != 0 || metrics["blks_sent"] != 0 {
+		t.Errorf("Seed sent %v bytes in %v blocks, want none", metrics["data_sent"], metrics["blks_sent"])
+	}
+	if sent := seed.LedgerForPeer(leech.Host().ID()).Sent; sent != 0 {
+		t.Errorf("Ledger of the seed has %d bytes sent, want 0", sent)
+	}
+}
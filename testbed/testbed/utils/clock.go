@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits. Test cases measure and wait with a Clock so
+// they can run on virtual time, see SimClock.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// RealClock is the wall clock.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// SimClock is a virtual clock for simulations. Time stands still while any
+// participant of the clock is running, and jumps to the next timer once all of
+// them are blocked on it. Participants are the goroutines started with Go;
+// they only wait on the clock they are given, so the timers they set decide
+// when they run again and a simulation of participants alone runs the same
+// every time. Timers fire one at a time, in the order they are due and then
+// in the order they were set.
+//
+// Goroutines that use the clock without taking part in it, such as the
+// exchange of a bitswap node, aren't accounted for. For them, time also waits
+// for the settle period of wall time with nothing setting a timer or touching
+// the clock, a heuristic: events only come in the same order on every run if
+// every reaction to an event takes less than it, which nothing enforces.
+type SimClock struct {
+	settle time.Duration
+
+	mu     sync.Mutex
+	now    time.Time
+	timers simTimers
+	seq    uint64
+	// Participants that aren't blocked on the clock.
+	running int
+
+	changed chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewSimClock starts a virtual clock at the given time. A settle period of 0
+// moves time as soon as every participant is blocked on the clock.
+func NewSimClock(start time.Time, settle time.Duration) *SimClock {
+	c := &SimClock{
+		settle:  settle,
+		now:     start,
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+func (c *SimClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// After and Sleep wait without blocking a participant on the clock, see Go.
+func (c *SimClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (c *SimClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// AfterFunc calls f with the virtual time once d has passed. f is called by
+// the clock and must not block.
+func (c *SimClock) AfterFunc(d time.Duration, f func(now time.Time)) {
+	c.addTimer(d, nil, f)
+}
+
+// Go runs f in a goroutine that takes part in the clock: time doesn't move
+// while f runs, only while it waits on the clock it is given. f must not wait
+// on anything else, and after calling After, it must wait on the channel
+// until the time has passed: it counts as blocked from the call on. Time may
+// move between the goroutines started by one that doesn't take part in the
+// clock, so participants that must start together are started by another.
+func (c *SimClock) Go(f func(clock Clock)) {
+	c.mu.Lock()
+	c.running++
+	c.mu.Unlock()
+	p := &simParticipant{clock: c}
+	go func() {
+		defer p.leave()
+		f(p)
+	}()
+}
+
+// Touch tells the clock something happened, holding time for another settle
+// period.
+func (c *SimClock) Touch() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops the clock. Pending timers never fire.
+func (c *SimClock) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+func (c *SimClock) addTimer(d time.Duration, p *simParticipant, f func(now time.Time)) {
+	c.mu.Lock()
+	if d < 0 {
+		d = 0
+	}
+	if p != nil && !p.blocked {
+		p.blocked = true
+		c.running--
+	}
+	c.seq++
+	heap.Push(&c.timers, &simTimer{c.now.Add(d), c.seq, p, f})
+	c.mu.Unlock()
+	c.Touch()
+}
+
+// idle tells if the next timer can fire, with every participant blocked.
+func (c *SimClock) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running == 0 && len(c.timers) > 0
+}
+
+func (c *SimClock) run() {
+	defer close(c.done)
+	for {
+		if !c.idle() {
+			select {
+			case <-c.stop:
+				return
+			case <-c.changed:
+			}
+			continue
+		}
+		if c.settle > 0 {
+			settled := time.NewTimer(c.settle)
+			select {
+			case <-c.stop:
+				settled.Stop()
+				return
+			case <-c.changed:
+				settled.Stop()
+				continue
+			case <-settled.C:
+			}
+		} else {
+			select {
+			case <-c.stop:
+				return
+			default:
+			}
+		}
+		c.fireNext()
+	}
+}
+
+func (c *SimClock) fireNext() {
+	c.mu.Lock()
+	if c.running > 0 || len(c.timers) == 0 {
+		c.mu.Unlock()
+		return
+	}
+	t := heap.Pop(&c.timers).(*simTimer)
+	if t.when.After(c.now) {
+		c.now = t.when
+	}
+	// The participant runs again from now on, so time waits for it before
+	// the next timer.
+	if p := t.p; p != nil && !p.left && p.blocked {
+		p.blocked = false
+		c.running++
+	}
+	now := c.now
+	c.mu.Unlock()
+	t.f(now)
+}
+
+// simParticipant is the clock of a participant of a SimClock. Its fields are
+// guarded by the mutex of the clock.
+type simParticipant struct {
+	clock   *SimClock
+	blocked bool
+	left    bool
+}
+
+func (p *simParticipant) Now() time.Time {
+	return p.clock.Now()
+}
+
+func (p *simParticipant) Since(t time.Time) time.Duration {
+	return p.clock.Since(t)
+}
+
+func (p *simParticipant) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	p.clock.addTimer(d, p, func(now time.Time) { ch <- now })
+	return ch
+}
+
+func (p *simParticipant) Sleep(d time.Duration) {
+	<-p.After(d)
+}
+
+func (p *simParticipant) leave() {
+	c := p.clock
+	c.mu.Lock()
+	if !p.blocked {
+		c.running--
+	}
+	p.left = true
+	c.mu.Unlock()
+	c.Touch()
+}
+
+type simTimer struct {
+	when time.Time
+	seq  uint64
+	// Participant blocked until the timer fires, if any.
+	p *simParticipant
+	f func(now time.Time)
+}
+
+// simTimers is a heap of timers, ordered by when they are due and then by
+// when they were set.
+type simTimers []*simTimer
+
+func (h simTimers) Len() int { return len(h) }
+func (h simTimers) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+func (h simTimers) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *simTimers) Push(x interface{}) { *h = append(*h, x.(*simTimer)) }
+func (h *simTimers) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return t
+}
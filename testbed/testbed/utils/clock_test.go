@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// simRecorder records the virtual times at which things happen.
+type simRecorder struct {
+	mu    sync.Mutex
+	order []string
+	at    map[string]time.Duration
+}
+
+func newSimRecorder() *simRecorder {
+	return &simRecorder{at: make(map[string]time.Duration)}
+}
+
+func (r *simRecorder) record(name string, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.order = append(r.order, name)
+	r.at[name] = now.Sub(testEpoch)
+}
+
+// wait waits for the participants of a test, failing it if they take too long
+// in wall time.
+func wait(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Simulation didn't end")
+	}
+}
+
+func TestSimClockTimerOrder(t *testing.T) {
+	c := NewSimClock(testEpoch, 0)
+	defer c.Stop()
+	r := newSimRecorder()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.Go(func(clock Clock) {
+		defer wg.Done()
+		for _, timer := range []struct {
+			name string
+			d    time.Duration
+		}{{"30ms", 30 * time.Millisecond}, {"10ms", 10 * time.Millisecond}, {"20ms", 20 * time.Millisecond}, {"10ms again", 10 * time.Millisecond}, {"past", -time.Second}} {
+			name := timer.name
+			c.AfterFunc(timer.d, func(now time.Time) { r.record(name, now) })
+		}
+		// None of the timers fires while the participant runs.
+		time.Sleep(20 * time.Millisecond)
+		r.mu.Lock()
+		if len(r.order) != 0 {
+			t.Errorf("Timers %v fired while a participant was running", r.order)
+		}
+		r.mu.Unlock()
+		clock.Sleep(time.Hour)
+	})
+	wait(t, &wg)
+
+	want := []string{"past", "10ms", "10ms again", "20ms", "30ms"}
+	if len(r.order) != len(want) {
+		t.Fatalf("Timers fired in the order %v, want %v", r.order, want)
+	}
+	for i, name := range want {
+		if r.order[i] != name {
+			t.Fatalf("Timers fired in the order %v, want %v", r.order, want)
+		}
+	}
+	if r.at["past"] != 0 || r.at["10ms again"] != 10*time.Millisecond || r.at["30ms"] != 30*time.Millisecond {
+		t.Errorf("Timers fired at %v", r.at)
+	}
+	if now := c.Since(testEpoch); now != time.Hour {
+		t.Errorf("Clock is at %s, want 1h", now)
+	}
+}
+
+func TestSimClockSleepAfter(t *testing.T) {
+	c := NewSimClock(testEpoch, 0)
+	defer c.Stop()
+	r := newSimRecorder()
+	var wg sync.WaitGroup
+	wg.Add(3)
+	c.Go(func(clock Clock) {
+		defer wg.Done()
+		for i := 1; i <= 3; i++ {
+			clock.Sleep(time.Hour)
+			r.record(fmt.Sprintf("sleep %d", i), clock.Now())
+		}
+	})
+	c.Go(func(clock Clock) {
+		defer wg.Done()
+		start := clock.Now()
+		for i := 1; i <= 2; i++ {
+			now := <-clock.After(90 * time.Minute)
+			r.record(fmt.Sprintf("after %d", i), now)
+		}
+		if d := clock.Since(start); d != 3*time.Hour {
+			t.Errorf("After waited %s, want 3h", d)
+		}
+	})
+	// Time waits for participants that are busy in wall time.
+	c.Go(func(clock Clock) {
+		defer wg.Done()
+		time.Sleep(50 * time.Millisecond)
+		r.record("busy", clock.Now())
+	})
+	start := time.Now()
+	wait(t, &wg)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("3 hours of virtual time took %s", d)
+	}
+
+	want := map[string]time.Duration{
+		"busy":    0,
+		"sleep 1": time.Hour,
+		"sleep 2": 2 * time.Hour,
+		"sleep 3": 3 * time.Hour,
+		"after 1": 90 * time.Minute,
+		"after 2": 3 * time.Hour,
+	}
+	for name, at := range want {
+		if got, ok := r.at[name]; !ok || got != at {
+			t.Errorf("%s at %s, want %s", name, got, at)
+		}
+	}
+	// Timers due at the same time fire in the order they were set.
+	if r.order[len(r.order)-2] != "after 2" || r.order[len(r.order)-1] != "sleep 3" {
+		t.Errorf("Events in the order %v", r.order)
+	}
+}
+
+func TestSimClockSettle(t *testing.T) {
+	c := NewSimClock(testEpoch, 20*time.Millisecond)
+	defer c.Stop()
+	start := time.Now()
+	c.Sleep(time.Hour)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("Time moved on after %s, before the settle period", d)
+	}
+	if now := c.Since(testEpoch); now != time.Hour {
+		t.Errorf("Clock is at %s, want 1h", now)
+	}
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DatasetManifest describes the files of a custom dataset, read from a YAML
+// or JSON file, e.g.
+//
+//	items:
+//	  - {type: random, size: 1048576, seed: 1}
+//	  - {type: path, path: movies/trailer.mp4}
+//	  - {type: tree, depth: 2, fanout: 4, files: 200, sizes: "lognormal:4096:1", seed: 2}
+//	  - {type: duplicated, size: 4194304, dup_ratio: 0.5, seed: 3}
+type DatasetManifest struct {
+	Items []DatasetItem `json:"items" yaml:"items"`
+}
+
+// DatasetItem is a file of a custom dataset. Which fields apply depends on
+// its type:
+// - random: a random file of size bytes generated from seed.
+// - path: the file or directory at path, relative to data_dir unless
+// absolute.
+// - tree: a directory tree of the given depth and fanout with files files,
+// whose sizes are drawn from sizes (see ParseSizeDistribution), or are all
+// size bytes.
+// - duplicated: a random file where dup_ratio of its chunks repeat earlier
+// ones.
+type DatasetItem struct {
+	Type     string  `json:"type" yaml:"type"`
+	Size     int64   `json:"size" yaml:"size"`
+	Seed     int64   `json:"seed" yaml:"seed"`
+	Path     string  `json:"path" yaml:"path"`
+	Depth    int     `json:"depth" yaml:"depth"`
+	Fanout   int     `json:"fanout" yaml:"fanout"`
+	Files    int     `json:"files" yaml:"files"`
+	Sizes    string  `json:"sizes" yaml:"sizes"`
+	DupRatio float64 `json:"dup_ratio" yaml:"dup_ratio"`
+}
+
+// ReadDatasetManifest reads the manifest at path, as JSON if its extension is
+// .json and as YAML otherwise.
+func ReadDatasetManifest(path string) (*DatasetManifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m DatasetManifest
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		// Reject unknown fields like the YAML decoder does, so typos in
+		// field names don't go unnoticed.
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&m)
+	} else {
+		err = yaml.UnmarshalStrict(data, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading dataset manifest %s: %w", path, err)
+	}
+	if len(m.Items) == 0 {
+		return nil, fmt.Errorf("Dataset manifest %s has no items", path)
+	}
+	return &m, nil
+}
+
+// TestFiles returns the test files of the items of the manifest, with paths
+// relative to dataDir.
+func (m *DatasetManifest) TestFiles(dataDir string) ([]TestFile, error) {
+	var testFiles []TestFile
+	for i, item := range m.Items {
+		f, err := item.testFile(dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("Dataset item %d: %w", i, err)
+		}
+		testFiles = append(testFiles, f)
+	}
+	return testFiles, nil
+}
+
+func (item DatasetItem) testFile(dataDir string) (TestFile, error) {
+	switch item.Type {
+	case "random":
+		if item.Size <= 0 {
+			return nil, fmt.Errorf("Random files need a positive size")
+		}
+		return NewRandFile(item.Size, item.Seed), nil
+	case "path":
+		if item.Path == "" {
+			return nil, fmt.Errorf("Path files need a path")
+		}
+		path := item.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dataDir, path)
+		}
+		return NewPathFile(path)
+	case "tree":
+		dist := item.Sizes
+		if dist == "" {
+			dist = strconv.FormatInt(item.Size, 10)
+		}
+		sizes, err := ParseSizeDistribution(dist)
+		if err != nil {
+			return nil, err
+		}
+		return NewTreeFile(item.Depth, item.Fanout, item.Files, sizes, item.Seed)
+	case "duplicated":
+		if item.Size <= 0 {
+			return nil, fmt.Errorf("Duplicated files need a positive size")
+		}
+		if item.DupRatio < 0 || item.DupRatio > 1 {
+			return nil, fmt.Errorf("dup_ratio must be between 0 and 1, got %v", item.DupRatio)
+		}
+		return NewDupFile(item.Size, item.Seed, item.DupRatio), nil
+	default:
+		return nil, fmt.Errorf("Dataset item type %s not implemented", item.Type)
+	}
+}
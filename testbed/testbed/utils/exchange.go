@@ -18,14 +18,15 @@ import (
 type ExchangeOpt func(helpers.MetricsCtx, fx.Lifecycle, host.Host,
 	routing.Routing, blockstore.GCBlockstore) exchange.Interface
 
-// SetExchange sets the exchange interface to be used
-func SetExchange(ctx context.Context, name string) (ExchangeOpt, error) {
+// SetExchange sets the exchange interface to be used. Message hooks, if any,
+// are run on every message the exchange sends and receives.
+func SetExchange(ctx context.Context, name string, hooks ...MessageHooks) (ExchangeOpt, error) {
 	switch name {
 	case "bitswap":
 		// Initializing bitswap exchange
 		return func(mctx helpers.MetricsCtx, lc fx.Lifecycle,
 			host host.Host, rt routing.Routing, bs blockstore.GCBlockstore) exchange.Interface {
-			bitswapNetwork := network.NewFromIpfsHost(host, rt)
+			bitswapNetwork := WrapBitswapNetwork(network.NewFromIpfsHost(host, rt), hooks...)
 			exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bs)
 
 			lc.Append(fx.Hook{
@@ -31,6 +31,11 @@ type RandFile struct {
 	seed int64
 }
 
+// NewRandFile returns a random file of the given size generated from seed.
+func NewRandFile(size int64, seed int64) *RandFile {
+	return &RandFile{size: size, seed: seed}
+}
+
 // PathFile is a generated from file.
 type PathFile struct {
 	Path  string
@@ -38,11 +43,26 @@ type PathFile struct {
 	isDir bool
 }
 
+// NewPathFile returns the file or directory at path.
+func NewPathFile(path string) (*PathFile, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+	if st.IsDir() {
+		if size, err = dirSize(path); err != nil {
+			return nil, err
+		}
+	}
+	return &PathFile{Path: path, size: size, isDir: st.IsDir()}, nil
+}
+
 // GenerateFile generates new randomly generated file
 func (f *RandFile) GenerateFile() (files.Node, error) {
 	r := SeededRandReader(int(f.size), f.seed)
 
-	path := fmt.Sprintf("/tmp-%d", rand.Uint64())
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("tmp-%d", rand.Uint64()))
 	tf, err := os.Create(path)
 	if err != nil {
 		return nil, err
@@ -63,6 +83,11 @@ func (f *RandFile) Size() int64 {
 	return f.size
 }
 
+// Seed returns the seed the file is generated from.
+func (f *RandFile) Seed() int64 {
+	return f.seed
+}
+
 // Size returns size
 func (f *PathFile) Size() int64 {
 	return f.size
@@ -77,6 +102,51 @@ func (f *PathFile) GenerateFile() (files.Node, error) {
 	return tmpFile, nil
 }
 
+// DupFile is a random file generated from a seed in which a ratio of its
+// chunks repeat earlier ones, to test how exchanges deal with duplicate
+// blocks. Chunks are as large as the chunks nodes split files in.
+type DupFile struct {
+	size     int64
+	seed     int64
+	dupRatio float64
+}
+
+// dupChunkSize is the size of the chunks of the default chunker,
+// size-262144.
+const dupChunkSize = 256 * 1024
+
+// NewDupFile returns a random file of the given size generated from seed,
+// where dupRatio of its full chunks after the first one repeat earlier
+// chunks.
+func NewDupFile(size int64, seed int64, dupRatio float64) *DupFile {
+	return &DupFile{size, seed, dupRatio}
+}
+
+// GenerateFile generates the file.
+func (f *DupFile) GenerateFile() (files.Node, error) {
+	r := rand.New(rand.NewSource(f.seed))
+	data := make([]byte, f.size)
+	for off := int64(0); off < f.size; off += dupChunkSize {
+		end := off + dupChunkSize
+		if end > f.size {
+			end = f.size
+		}
+		chunks := off / dupChunkSize
+		if chunks > 0 && end-off == dupChunkSize && r.Float64() < f.dupRatio {
+			src := r.Int63n(chunks) * dupChunkSize
+			copy(data[off:end], data[src:src+dupChunkSize])
+			continue
+		}
+		r.Read(data[off:end])
+	}
+	return files.NewBytesFile(data), nil
+}
+
+// Size returns size
+func (f *DupFile) Size() int64 {
+	return f.size
+}
+
 // RandFromReader Generates random file from existing reader
 func RandFromReader(randReader *rand.Rand, len int) io.Reader {
 	if randReader == nil {
@@ -159,8 +229,30 @@ func GetFileList(runenv *runtime.RunEnv) ([]TestFile, error) {
 			listFiles = append(listFiles, &RandFile{size: int64(v), seed: int64(i)})
 		}
 		return listFiles, nil
+	case "tree":
+		sizes, err := ParseSizeDistribution(runenv.StringParam("tree_file_sizes"))
+		if err != nil {
+			return nil, err
+		}
+		tree, err := NewTreeFile(runenv.IntParam("tree_depth"), runenv.IntParam("tree_fanout"),
+			runenv.IntParam("tree_files"), sizes, int64(runenv.IntParam("tree_seed")))
+		if err != nil {
+			return nil, err
+		}
+		runenv.RecordMessage("Getting file list for %s", tree)
+		return append(listFiles, tree), nil
 	case "custom":
-		return nil, fmt.Errorf("Custom inputData not implemented yet")
+		dataDir := runenv.StringParam("data_dir")
+		path := runenv.StringParam("input_manifest")
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dataDir, path)
+		}
+		runenv.RecordMessage("Getting file list from dataset manifest %s", path)
+		manifest, err := ReadDatasetManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		return manifest.TestFiles(dataDir)
 	default:
 		return nil, fmt.Errorf("Inputdata type not implemented")
 	}
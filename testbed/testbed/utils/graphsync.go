@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipfs/go-blockservice"
@@ -35,17 +36,23 @@ type GraphsyncNode struct {
 	totalSent     uint64
 	totalReceived uint64
 	numSeeds      int
+	verifier      *BlockVerifier
+	timeline      *Timeline
 }
 
-func CreateGraphsyncNode(ctx context.Context, h host.Host, bstore blockstore.Blockstore, numSeeds int) (*GraphsyncNode, error) {
-	net := network.NewFromLibp2pHost(h)
+// CreateGraphsyncNode creates a graphsync node on top of the given host and
+// blockstore. Message hooks, if any, are run on every message the node sends
+// and receives.
+func CreateGraphsyncNode(ctx context.Context, h host.Host, bstore blockstore.Blockstore, numSeeds int, hooks ...GraphsyncMessageHooks) (*GraphsyncNode, error) {
+	verifier := NewBlockVerifier()
+	net := WrapGraphsyncNetwork(network.NewFromLibp2pHost(h), append(hooks, verifier.GraphsyncHooks())...)
 	bserv := blockservice.New(bstore, offline.Exchange(bstore))
 	dserv := merkledag.NewDAGService(bserv)
 	gs := gsimpl.New(ctx, net,
 		storeutil.LoaderForBlockstore(bstore),
 		storeutil.StorerForBlockstore(bstore),
 	)
-	n := &GraphsyncNode{gs, bstore, dserv, h, 0, 0, numSeeds}
+	n := &GraphsyncNode{gs, bstore, dserv, h, 0, 0, numSeeds, verifier, NewTimeline()}
 	gs.RegisterBlockSentListener(n.onDataSent)
 	gs.RegisterIncomingBlockHook(n.onDataReceived)
 	gs.RegisterIncomingRequestHook(n.onIncomingRequestHook)
@@ -81,9 +88,18 @@ func (n *GraphsyncNode) ClearDatastore(ctx context.Context, rootCid cid.Cid) err
 func (n *GraphsyncNode) EmitMetrics(recorder MetricsRecorder) error {
 	recorder.Record("data_sent", float64(n.totalSent))
 	recorder.Record("data_rcvd", float64(n.totalReceived))
+	n.verifier.EmitMetrics(recorder)
+	emitBlockstoreMetrics(n.blockStore, recorder)
 	return nil
 }
 
+func (n *GraphsyncNode) ExchangeStats() (ExchangeSample, error) {
+	return ExchangeSample{
+		DataSent:     atomic.LoadUint64(&n.totalSent),
+		DataReceived: atomic.LoadUint64(&n.totalReceived),
+	}, nil
+}
+
 func (n *GraphsyncNode) Fetch(ctx context.Context, c cid.Cid, peers []PeerInfo) (files.Node, error) {
 	leechIndex := 0
 	for i := 0; i < len(peers); i++ {
@@ -125,6 +141,7 @@ func (n *GraphsyncNode) Fetch(ctx context.Context, c cid.Cid, peers []PeerInfo)
 		}
 	}
 	if lastError != nil {
+		n.verifier.lose(time.Since(start))
 		return nil, lastError
 	}
 	nd, err := n.dserv.Get(ctx, c)
@@ -143,6 +160,11 @@ func (n *GraphsyncNode) Host() host.Host {
 	return n.h
 }
 
+// Timeline returns the arrival timeline of the blocks the node fetches.
+func (n *GraphsyncNode) Timeline() *Timeline {
+	return n.timeline
+}
+
 func (n *GraphsyncNode) EmitKeepAlive(recorder MessageRecorder) error {
 
 	recorder.RecordMessage("I am still alive! Total In: %d - TotalOut: %d",
@@ -153,11 +175,18 @@ func (n *GraphsyncNode) EmitKeepAlive(recorder MessageRecorder) error {
 }
 
 func (n *GraphsyncNode) onDataSent(p peer.ID, request graphsync.RequestData, block graphsync.BlockData) {
-	n.totalSent += block.BlockSizeOnWire()
+	atomic.AddUint64(&n.totalSent, block.BlockSizeOnWire())
 }
 
 func (n *GraphsyncNode) onDataReceived(p peer.ID, request graphsync.ResponseData, block graphsync.BlockData, ha graphsync.IncomingBlockHookActions) {
-	n.totalReceived += block.BlockSizeOnWire()
+	atomic.AddUint64(&n.totalReceived, block.BlockSizeOnWire())
+	if link, ok := block.Link().(cidlink.Link); ok {
+		n.verifier.used(link.Cid)
+		// Blocks loaded from the local store don't count as arrivals.
+		if block.BlockSizeOnWire() > 0 {
+			n.timeline.arrive(link.Cid, p, int(block.BlockSize()))
+		}
+	}
 }
 
 func (n *GraphsyncNode) onIncomingRequestHook(p peer.ID, request graphsync.RequestData, ha graphsync.IncomingRequestHookActions) {
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+
+	gsmsg "github.com/ipfs/go-graphsync/message"
+	gsnet "github.com/ipfs/go-graphsync/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// GraphsyncMessageHook inspects or rewrites a graphsync message exchanged with
+// peer p. Returning nil drops the message.
+type GraphsyncMessageHook func(ctx context.Context, p peer.ID, msg gsmsg.GraphSyncMessage) gsmsg.GraphSyncMessage
+
+// GraphsyncMessageHooks are run on the messages a graphsync node sends
+// (Outgoing) and receives (Incoming). Either of them may be nil.
+type GraphsyncMessageHooks struct {
+	Outgoing GraphsyncMessageHook
+	Incoming GraphsyncMessageHook
+}
+
+// hookedGraphsyncNetwork runs message hooks on top of a graphsync network.
+type hookedGraphsyncNetwork struct {
+	gsnet.GraphSyncNetwork
+	hooks []GraphsyncMessageHooks
+}
+
+// WrapGraphsyncNetwork returns a network that runs the given hooks, in order,
+// on every message before it is sent or delivered to graphsync.
+func WrapGraphsyncNetwork(net gsnet.GraphSyncNetwork, hooks ...GraphsyncMessageHooks) gsnet.GraphSyncNetwork {
+	if len(hooks) == 0 {
+		return net
+	}
+	return &hookedGraphsyncNetwork{net, hooks}
+}
+
+func (n *hookedGraphsyncNetwork) outgoing(ctx context.Context, p peer.ID, msg gsmsg.GraphSyncMessage) gsmsg.GraphSyncMessage {
+	for _, h := range n.hooks {
+		if h.Outgoing == nil {
+			continue
+		}
+		if msg = h.Outgoing(ctx, p, msg); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
+
+func (n *hookedGraphsyncNetwork) incoming(ctx context.Context, p peer.ID, msg gsmsg.GraphSyncMessage) gsmsg.GraphSyncMessage {
+	for _, h := range n.hooks {
+		if h.Incoming == nil {
+			continue
+		}
+		if msg = h.Incoming(ctx, p, msg); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
+
+func (n *hookedGraphsyncNetwork) SendMessage(ctx context.Context, p peer.ID, msg gsmsg.GraphSyncMessage) error {
+	if msg = n.outgoing(ctx, p, msg); msg == nil {
+		return nil
+	}
+	return n.GraphSyncNetwork.SendMessage(ctx, p, msg)
+}
+
+func (n *hookedGraphsyncNetwork) NewMessageSender(ctx context.Context, p peer.ID) (gsnet.MessageSender, error) {
+	sender, err := n.GraphSyncNetwork.NewMessageSender(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedGraphsyncSender{sender, n, p}, nil
+}
+
+func (n *hookedGraphsyncNetwork) SetDelegate(r gsnet.Receiver) {
+	n.GraphSyncNetwork.SetDelegate(&hookedGraphsyncReceiver{r, n})
+}
+
+type hookedGraphsyncSender struct {
+	gsnet.MessageSender
+	n *hookedGraphsyncNetwork
+	p peer.ID
+}
+
+func (s *hookedGraphsyncSender) SendMsg(ctx context.Context, msg gsmsg.GraphSyncMessage) error {
+	if msg = s.n.outgoing(ctx, s.p, msg); msg == nil {
+		return nil
+	}
+	return s.MessageSender.SendMsg(ctx, msg)
+}
+
+type hookedGraphsyncReceiver struct {
+	gsnet.Receiver
+	n *hookedGraphsyncNetwork
+}
+
+func (r *hookedGraphsyncReceiver) ReceiveMessage(ctx context.Context, sender peer.ID, incoming gsmsg.GraphSyncMessage) {
+	if incoming = r.n.incoming(ctx, sender, incoming); incoming == nil {
+		return
+	}
+	r.Receiver.ReceiveMessage(ctx, sender, incoming)
+}
@@ -7,7 +7,6 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"time"
 
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
@@ -17,29 +16,46 @@ import (
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
+// HTTPPort is the port HTTP seeds serve files on in the test cases.
+const HTTPPort = 8080
+
 type HTTPNode struct {
-	h   host.Host
-	svc *http.Server
+	h    host.Host
+	svc  *http.Server
+	port int
 }
 
-func CreateHTTPNode(ctx context.Context, h host.Host, nodeTP NodeType) (*HTTPNode, error) {
+// CreateHTTPNode creates an HTTP node. Seeds serve files on port, or on a
+// free port if it is 0 (see Port), and leeches fetch them from the seed on
+// port.
+func CreateHTTPNode(ctx context.Context, h host.Host, nodeTP NodeType, port int) (*HTTPNode, error) {
 	var svr *http.Server
 	switch nodeTP {
 	case Seed:
-		svr = &http.Server{Addr: ":8080"}
-		go svr.ListenAndServe()
-		time.Sleep(1 * time.Second)
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return nil, err
+		}
+		port = l.Addr().(*net.TCPAddr).Port
+		svr = &http.Server{}
+		go svr.Serve(l)
 	case Leech:
 	default:
 		return nil, errors.New("nodeType NOT supported")
 	}
 
 	return &HTTPNode{
-		h:   h,
-		svc: svr,
+		h:    h,
+		svc:  svr,
+		port: port,
 	}, nil
 }
 
+// Port returns the port the node serves files on, or fetches them from.
+func (h *HTTPNode) Port() int {
+	return h.port
+}
+
 func (h *HTTPNode) Add(ctx context.Context, file files.Node) (cid.Cid, error) {
 	f := files.ToFile(file)
 	if f == nil {
@@ -89,7 +105,7 @@ func (h *HTTPNode) Fetch(ctx context.Context, c cid.Cid, peers []PeerInfo) (file
 		}
 	}
 
-	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s:8080/%s", ip.String(), c.String()))
+	resp, err := http.DefaultClient.Get(fmt.Sprintf("http://%s:%d/%s", ip.String(), h.port, c.String()))
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +114,7 @@ func (h *HTTPNode) Fetch(ctx context.Context, c cid.Cid, peers []PeerInfo) (file
 }
 
 func (h *HTTPNode) Host() host.Host {
-	return h.Host()
+	return h.h
 }
 
 // NOOP FOR now,
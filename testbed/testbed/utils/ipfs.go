@@ -47,6 +47,8 @@ type IPFSNode struct {
 	Node  *core.IpfsNode
 	API   icore.CoreAPI
 	Close func() error
+
+	bstoreStats *BlockstoreStats
 }
 
 type NodeConfig struct {
@@ -120,7 +122,7 @@ func baseProcess(lc fx.Lifecycle) goprocess.Process {
 }
 
 // setConfig manually injects dependencies for the IPFS nodes.
-func setConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, DHTenabled bool, providingEnabled bool) fx.Option {
+func setConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, hostOpt libp2p.HostOption, DHTenabled bool, providingEnabled bool, bstoreStats *BlockstoreStats) fx.Option {
 
 	// Create new Datastore
 	// TODO: This is in memory we should have some other external DataStore for big files.
@@ -138,8 +140,9 @@ func setConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, DHTen
 	cfg.Identity.PrivKey = base64.StdEncoding.EncodeToString(nConfig.PrivKey)
 
 	// Repo structure that encapsulate the config and datastore for dependency injection.
+	// Block lookups reaching the datastore are cache misses of the blockstore.
 	buildRepo := &repo.Mock{
-		D: dsync.MutexWrap(d),
+		D: CountLookups(dsync.MutexWrap(d), bstoreStats),
 		C: *cfg,
 	}
 	repoOption := fx.Provide(func(lc fx.Lifecycle) repo.Repo {
@@ -156,9 +159,8 @@ func setConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, DHTen
 		return helpers.MetricsCtx(ctx)
 	})
 
-	// Use DefaultHostOptions
 	hostOption := fx.Provide(func() libp2p.HostOption {
-		return libp2p.DefaultHostOption
+		return hostOpt
 	})
 
 	dhtOption := libp2p.NilRouterOption
@@ -204,8 +206,8 @@ func setConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, DHTen
 
 		// Storage configuration
 		fx.Provide(repoDS),
-		fx.Provide(node.BaseBlockstoreCtor(blockstore.DefaultCacheOpts(),
-			false, cfg.Datastore.HashOnRead)),
+		fx.Provide(instrumentedBlockstoreCtor(node.BaseBlockstoreCtor(blockstore.DefaultCacheOpts(),
+			false, cfg.Datastore.HashOnRead), bstoreStats)),
 		fx.Provide(node.GcBlockstoreCtor),
 
 		// Identity dependencies
@@ -258,8 +260,27 @@ func setConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, DHTen
 	)
 }
 
+// instrumentedBlockstoreCtor wraps the base blockstore constructor so the
+// operations on the blockstore of the node are accounted for in stats.
+func instrumentedBlockstoreCtor(ctor func(helpers.MetricsCtx, repo.Repo, fx.Lifecycle) (node.BaseBlocks, error),
+	stats *BlockstoreStats) func(helpers.MetricsCtx, repo.Repo, fx.Lifecycle) (node.BaseBlocks, error) {
+	return func(mctx helpers.MetricsCtx, r repo.Repo, lc fx.Lifecycle) (node.BaseBlocks, error) {
+		bs, err := ctor(mctx, r, lc)
+		if err != nil {
+			return nil, err
+		}
+		return InstrumentBlockstore(bs, stats), nil
+	}
+}
+
 // CreateIPFSNodeWithConfig constructs and returns an IpfsNode using the given cfg.
 func CreateIPFSNodeWithConfig(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, DHTEnabled bool, providingEnabled bool) (*IPFSNode, error) {
+	return CreateIPFSNodeWithHost(ctx, nConfig, exch, libp2p.DefaultHostOption, DHTEnabled, providingEnabled)
+}
+
+// CreateIPFSNodeWithHost constructs an IpfsNode whose libp2p host is built by
+// hostOpt instead of the default one, e.g. to add it to a mocknet.
+func CreateIPFSNodeWithHost(ctx context.Context, nConfig *NodeConfig, exch ExchangeOpt, hostOpt libp2p.HostOption, DHTEnabled bool, providingEnabled bool) (*IPFSNode, error) {
 	// save this context as the "lifetime" ctx.
 	lctx := ctx
 
@@ -270,10 +291,11 @@ func CreateIPFSNodeWithConfig(ctx context.Context, nConfig *NodeConfig, exch Exc
 	ctx = metrics.CtxScope(ctx, "ipfs")
 
 	n := &core.IpfsNode{}
+	bstoreStats := NewBlockstoreStats()
 
 	app := fx.New(
 		// Inject dependencies in the node.
-		setConfig(ctx, nConfig, exch, DHTEnabled, providingEnabled),
+		setConfig(ctx, nConfig, exch, hostOpt, DHTEnabled, providingEnabled, bstoreStats),
 
 		fx.NopLogger,
 		fx.Extract(n),
@@ -327,7 +349,7 @@ func CreateIPFSNodeWithConfig(ctx context.Context, nConfig *NodeConfig, exch Exc
 	}
 
 	// Attach the Core API to the constructed node
-	return &IPFSNode{n, api, stopNode}, nil
+	return &IPFSNode{n, api, stopNode, bstoreStats}, nil
 }
 
 // ClearDatastore removes a block from the datastore.
@@ -355,6 +377,18 @@ func (n *IPFSNode) ClearDatastore(ctx context.Context, rootCid cid.Cid) error {
 	})
 }
 
+func (n *IPFSNode) ExchangeStats() (ExchangeSample, error) {
+	bsnode, ok := n.Node.Exchange.(*bs.Bitswap)
+	if !ok {
+		return ExchangeSample{}, fmt.Errorf("Exchange %T doesn't report stats", n.Node.Exchange)
+	}
+	stats, err := bsnode.Stat()
+	if err != nil {
+		return ExchangeSample{}, err
+	}
+	return bitswapSample(stats), nil
+}
+
 // EmitMetrics emits node's metrics for the run
 func (n *IPFSNode) EmitMetrics(recorder MetricsRecorder) error {
 	// TODO: We ned a way of generalizing this for any exchange type
@@ -385,17 +419,12 @@ func (n *IPFSNode) EmitMetrics(recorder MetricsRecorder) error {
 	recorder.Record("rate_in", float64(bwTotal.RateIn))
 	recorder.Record("rate_out", float64(bwTotal.RateOut))
 
+	n.bstoreStats.EmitMetrics(recorder)
+
 	// Restart all counters for the next test.
 	n.Node.Reporter.Reset()
 	n.Node.Exchange.(*bs.Bitswap).ResetStatCounters()
 
-	// A few other metrics that could be collected.
-	// GetBandwidthForPeer(peer.ID) Stats
-	// GetBandwidthForProtocol(protocol.ID) Stats
-	// GetBandwidthTotals() Stats
-	// GetBandwidthByPeer() map[peer.ID]Stats
-	// GetBandwidthByProtocol() map[protocol.ID]Stats
-
 	return nil
 }
 
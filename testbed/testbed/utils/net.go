@@ -25,7 +25,7 @@ func SetupNetwork(ctx context.Context, runenv *runtime.RunEnv,
 		return err
 	}
 
-	latency, err := getLatency(runenv, nodetp, tpindex, baseLatency)
+	latency, err := NodeLatency(runenv, nodetp, tpindex, baseLatency)
 	if err != nil {
 		return err
 	}
@@ -48,8 +48,9 @@ func SetupNetwork(ctx context.Context, runenv *runtime.RunEnv,
 	return nwClient.ConfigureNetwork(ctx, cfg)
 }
 
-// If there's a latency specific to the node type, overwrite the default latency
-func getLatency(runenv *runtime.RunEnv, nodetp NodeType, tpindex int, baseLatency time.Duration) (time.Duration, error) {
+// NodeLatency returns the latency of a node. If there's a latency specific to
+// the node type, overwrite the default latency
+func NodeLatency(runenv *runtime.RunEnv, nodetp NodeType, tpindex int, baseLatency time.Duration) (time.Duration, error) {
 	if nodetp == Seed {
 		return getTypeLatency(runenv, "seed_latency_ms", tpindex, baseLatency)
 	} else if nodetp == Leech {
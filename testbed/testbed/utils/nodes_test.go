@@ -0,0 +1,386 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	files "github.com/ipfs/go-ipfs-files"
+	coremock "github.com/ipfs/go-ipfs/core/mock"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+// nodeCase creates the seeds and leeches of a Node implementation on a
+// mocknet.
+type nodeCase struct {
+	name   string
+	create func(ctx context.Context, t *testing.T, mn mocknet.Mocknet, nodetp NodeType) Node
+	// Whether the node transfers directories, or only single files.
+	dirs bool
+	// Metrics the leech records a positive value of once it fetched a file.
+	metrics []string
+	// stored returns whether the block is in the store of the node, for
+	// nodes that store the blocks they fetch.
+	stored func(n Node, c cid.Cid) (bool, error)
+}
+
+var exchangeMetrics = []string{"data_rcvd", "blks_rcvd"}
+
+// httpSeedPort is the port the HTTP seed of the running test serves files on.
+var httpSeedPort int
+
+var nodeCases = []nodeCase{{
+	name:    "ipfs",
+	create:  createIPFSNode,
+	dirs:    true,
+	metrics: exchangeMetrics,
+	stored: func(n Node, c cid.Cid) (bool, error) {
+		return n.(*IPFSNode).Node.Blockstore.Has(c)
+	},
+}, {
+	name: "bitswap",
+	create: func(ctx context.Context, t *testing.T, mn mocknet.Mocknet, _ NodeType) Node {
+		n, err := CreateBitswapNode(ctx, newMockHost(t, mn), newBlockstore(ctx, t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		return n
+	},
+	dirs:    true,
+	metrics: exchangeMetrics,
+	stored: func(n Node, c cid.Cid) (bool, error) {
+		return n.(*BitswapNode).Blockstore().Has(c)
+	},
+}, {
+	name: "graphsync",
+	create: func(ctx context.Context, t *testing.T, mn mocknet.Mocknet, _ NodeType) Node {
+		n, err := CreateGraphsyncNode(ctx, newMockHost(t, mn), newBlockstore(ctx, t), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	},
+	dirs:    true,
+	metrics: []string{"data_rcvd"},
+	stored: func(n Node, c cid.Cid) (bool, error) {
+		return n.(*GraphsyncNode).blockStore.Has(c)
+	},
+}, {
+	name: "libp2pHTTP",
+	create: func(ctx context.Context, t *testing.T, mn mocknet.Mocknet, nodetp NodeType) Node {
+		n, err := CreateLibp2pHTTPNode(ctx, newMockHost(t, mn), nodetp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	},
+}, {
+	name: "rawLibp2p",
+	create: func(ctx context.Context, t *testing.T, mn mocknet.Mocknet, nodetp NodeType) Node {
+		n, err := CreateRawLibp2pNode(ctx, newMockHost(t, mn), nodetp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	},
+}, {
+	// The HTTP node serves files over plain HTTP on a free port of the
+	// loopback interface; only its peer is on the mocknet. Seeds are created
+	// first, so leeches fetch from the port of the latest seed.
+	name: "http",
+	create: func(ctx context.Context, t *testing.T, mn mocknet.Mocknet, nodetp NodeType) Node {
+		n, err := CreateHTTPNode(ctx, newMockHost(t, mn), nodetp, httpSeedPort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodetp == Seed {
+			httpSeedPort = n.Port()
+			t.Cleanup(func() {
+				n.svc.Close()
+				httpSeedPort = 0
+			})
+		}
+		return n
+	},
+}}
+
+type testFile struct {
+	name string
+	file TestFile
+	dir  bool
+}
+
+// TestNodes runs every Node implementation through a transfer from a seed to
+// a leech over a mocknet, of random and existing files and directories.
+func TestNodes(t *testing.T) {
+	testFiles := []testFile{
+		// Spans several chunks, with a partial last one.
+		{"rand", NewRandFile(3*256*1024+123, 1), false},
+		{"path", newPathFile(t, "file", 100*1024), false},
+		{"dir", newPathDir(t), true},
+		{"tree", newTreeFile(t), true},
+	}
+	for _, c := range nodeCases {
+		for _, f := range testFiles {
+			if f.dir && !c.dirs {
+				continue
+			}
+			c, f := c, f
+			t.Run(c.name+"/"+f.name, func(t *testing.T) {
+				testTransfer(t, c, f.file)
+			})
+		}
+	}
+}
+
+func testTransfer(t *testing.T, c nodeCase, f TestFile) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	mn := mocknet.New(ctx)
+	seed := c.create(ctx, t, mn, Seed)
+	leech := c.create(ctx, t, mn, Leech)
+	if err := mn.LinkAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	peers := []PeerInfo{
+		{peer.AddrInfo{ID: seed.Host().ID(), Addrs: seed.Host().Addrs()}, Seed, 0},
+		{peer.AddrInfo{ID: leech.Host().ID(), Addrs: leech.Host().Addrs()}, Leech, 0},
+	}
+
+	// Add. Publishing the root to the leech is up to the test cases, see
+	// TestInProcessTransfer.
+	added, err := f.GenerateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := seed.Add(ctx, added)
+	if err != nil {
+		t.Fatalf("Error adding file: %s", err)
+	}
+
+	// Fetch and verify.
+	fetched, err := leech.Fetch(ctx, root, peers)
+	if err != nil {
+		t.Fatalf("Error fetching %s: %s", root, err)
+	}
+	want, err := f.GenerateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFiles(t, want, fetched)
+	if c.stored != nil {
+		checkStored(t, c, leech, root, true)
+	}
+
+	// Metrics.
+	if err := seed.EmitMetrics(metricsMap{}); err != nil {
+		t.Fatalf("Error emitting metrics of the seed: %s", err)
+	}
+	leechMetrics := metricsMap{}
+	if err := leech.EmitMetrics(leechMetrics); err != nil {
+		t.Fatalf("Error emitting metrics of the leech: %s", err)
+	}
+	for _, m := range c.metrics {
+		if leechMetrics[m] <= 0 {
+			t.Errorf("Leech recorded %s %v", m, leechMetrics[m])
+		}
+	}
+
+	// Cleanup.
+	for _, n := range []Node{seed, leech} {
+		if err := n.ClearDatastore(ctx, root); err != nil {
+			t.Fatalf("Error clearing datastore: %s", err)
+		}
+	}
+	if c.stored != nil {
+		checkStored(t, c, seed, root, false)
+		checkStored(t, c, leech, root, false)
+	}
+}
+
+func checkStored(t *testing.T, c nodeCase, n Node, root cid.Cid, want bool) {
+	t.Helper()
+	has, err := c.stored(n, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has != want {
+		t.Errorf("Root block stored: %t, want %t", has, want)
+	}
+}
+
+// compareFiles checks that two files, or directory trees, have the same
+// paths and contents.
+func compareFiles(t *testing.T, want, got files.Node) {
+	t.Helper()
+	wantContents, err := readTree(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotContents, err := readTree(got)
+	if err != nil {
+		t.Fatalf("Error reading fetched file: %s", err)
+	}
+	if len(gotContents) != len(wantContents) {
+		t.Fatalf("Fetched %d files, want %d", len(gotContents), len(wantContents))
+	}
+	for path, w := range wantContents {
+		g, ok := gotContents[path]
+		if !ok {
+			t.Errorf("Missing %q", path)
+			continue
+		}
+		if !bytes.Equal(g, w) {
+			t.Errorf("%q has %d bytes that differ from the %d added", path, len(g), len(w))
+		}
+	}
+}
+
+func readTree(nd files.Node) (map[string][]byte, error) {
+	contents := make(map[string][]byte)
+	err := files.Walk(nd, func(path string, nd files.Node) error {
+		f, ok := nd.(files.File)
+		if !ok {
+			return nil
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		contents[path] = data
+		return nil
+	})
+	return contents, err
+}
+
+type metricsMap map[string]float64
+
+func (m metricsMap) Record(key string, value float64) {
+	m[key] = value
+}
+
+func createIPFSNode(ctx context.Context, t *testing.T, mn mocknet.Mocknet, _ NodeType) Node {
+	nConfig, err := GenerateAddrInfo("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exch, err := SetExchange(ctx, "bitswap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := CreateIPFSNodeWithHost(ctx, nConfig, exch, coremock.MockHostOption(mn), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// newMockHost adds a peer listening on the loopback interface to the mocknet.
+func newMockHost(t *testing.T, mn mocknet.Mocknet) host.Host {
+	nConfig, err := GenerateAddrInfo("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := crypto.UnmarshalPrivateKey(nConfig.PrivKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := mn.AddPeer(privKey, nConfig.AddrInfo.Addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func newBlockstore(ctx context.Context, t *testing.T) *InstrumentedBlockstore {
+	dstore, err := CreateDatastore(false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bstore, err := CreateBlockstore(ctx, dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bstore
+}
+
+// newPathFile writes a file of random data to a temporary directory.
+func newPathFile(t *testing.T, name string, size int) *PathFile {
+	path := filepath.Join(tempDir(t), name)
+	writeRandFile(t, path, size, 2)
+	return &PathFile{Path: path, size: int64(size)}
+}
+
+// newPathDir writes a directory tree with empty, small and multi-chunk files
+// to a temporary directory.
+func newPathDir(t *testing.T) *PathFile {
+	dir := filepath.Join(tempDir(t), "dir")
+	sizes := map[string]int{
+		"empty":              0,
+		"small":              1024,
+		"sub/large":          2*256*1024 + 1,
+		"sub/deeper/small":   10,
+		"sub/deeper/another": 4096,
+	}
+	var seed int64
+	for name, size := range sizes {
+		seed++
+		writeRandFile(t, filepath.Join(dir, name), size, seed)
+	}
+	size, err := dirSize(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PathFile{Path: dir, size: size, isDir: true}
+}
+
+// newTreeFile generates a tree of files of up to a few chunks.
+func newTreeFile(t *testing.T) *TreeFile {
+	sizes, err := ParseSizeDistribution("uniform:0:600000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTreeFile(2, 2, 12, sizes, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "nodes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeRandFile(t *testing.T, path string, size int, seed int64) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(SeededRandReader(size, seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
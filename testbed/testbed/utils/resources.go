@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ResourceSample is the resource usage of the process at a point in time.
+// CPU and GC figures are cumulative since the process started.
+type ResourceSample struct {
+	CPUUser    time.Duration `json:"cpu_user"`
+	CPUSystem  time.Duration `json:"cpu_system"`
+	RSS        uint64        `json:"rss"`
+	HeapAlloc  uint64        `json:"heap_alloc"`
+	HeapSys    uint64        `json:"heap_sys"`
+	Goroutines int           `json:"goroutines"`
+	NumGC      uint32        `json:"num_gc"`
+	GCPause    time.Duration `json:"gc_pause"`
+
+	// Pauses of the most recent GC cycles, kept to find the longest pause
+	// between two samples. Not written out.
+	pauses [256]uint64
+}
+
+func (ResourceSample) Probe() string { return "resources" }
+
+func (ResourceSample) Columns() []string {
+	return []string{"cpu_user", "cpu_system", "rss", "heap_alloc", "heap_sys", "goroutines", "num_gc", "gc_pause"}
+}
+
+func (s ResourceSample) Values() []string {
+	return []string{strconv.FormatInt(int64(s.CPUUser), 10), strconv.FormatInt(int64(s.CPUSystem), 10),
+		formatUint(s.RSS), formatUint(s.HeapAlloc), formatUint(s.HeapSys), strconv.Itoa(s.Goroutines),
+		formatUint(uint64(s.NumGC)), strconv.FormatInt(int64(s.GCPause), 10)}
+}
+
+// ReadResources samples the resource usage of the process. RSS is left at 0
+// where /proc is not available.
+func ReadResources() (ResourceSample, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return ResourceSample{}, fmt.Errorf("Error getting resource usage: %w", err)
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ResourceSample{
+		CPUUser:    time.Duration(ru.Utime.Nano()),
+		CPUSystem:  time.Duration(ru.Stime.Nano()),
+		RSS:        residentSetSize(),
+		HeapAlloc:  ms.HeapAlloc,
+		HeapSys:    ms.HeapSys,
+		Goroutines: runtime.NumGoroutine(),
+		NumGC:      ms.NumGC,
+		GCPause:    time.Duration(ms.PauseTotalNs),
+		pauses:     ms.PauseNs,
+	}, nil
+}
+
+func residentSetSize() uint64 {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// ResourceProbe samples the resource usage of the process.
+func ResourceProbe() Probe {
+	return func() []Sample {
+		s, err := ReadResources()
+		if err != nil {
+			return nil
+		}
+		return []Sample{s}
+	}
+}
+
+// ResourceMeter measures the resources used by the process over a span of
+// time, such as a fetch.
+type ResourceMeter struct {
+	start ResourceSample
+}
+
+// NewResourceMeter creates a meter starting now.
+func NewResourceMeter() (*ResourceMeter, error) {
+	m := &ResourceMeter{}
+	return m, m.Reset()
+}
+
+// Reset starts measuring again from now.
+func (m *ResourceMeter) Reset() error {
+	s, err := ReadResources()
+	if err != nil {
+		return err
+	}
+	m.start = s
+	return nil
+}
+
+// EmitMetrics records the CPU time and GC pauses since the meter started, and
+// the current memory use and goroutine count.
+func (m *ResourceMeter) EmitMetrics(recorder MetricsRecorder) error {
+	end, err := ReadResources()
+	if err != nil {
+		return err
+	}
+	recorder.Record("cpu_user", float64(end.CPUUser-m.start.CPUUser))
+	recorder.Record("cpu_system", float64(end.CPUSystem-m.start.CPUSystem))
+	if end.RSS > 0 {
+		recorder.Record("rss", float64(end.RSS))
+	}
+	recorder.Record("heap_alloc", float64(end.HeapAlloc))
+	recorder.Record("heap_sys", float64(end.HeapSys))
+	recorder.Record("goroutines", float64(end.Goroutines))
+	recorder.Record("gc_count", float64(end.NumGC-m.start.NumGC))
+	recorder.Record("gc_pause", float64(end.GCPause-m.start.GCPause))
+	recorder.Record("gc_pause_max", float64(maxPause(end, m.start.NumGC)))
+	return nil
+}
+
+// maxPause returns the longest GC pause after cycle since, out of the cycles
+// s still keeps.
+func maxPause(s ResourceSample, since uint32) time.Duration {
+	n := s.NumGC - since
+	if n > uint32(len(s.pauses)) {
+		n = uint32(len(s.pauses))
+	}
+	var longest uint64
+	for i := uint32(0); i < n; i++ {
+		// The pause of cycle k is at index (k+255)%256.
+		p := s.pauses[(s.NumGC-i+uint32(len(s.pauses))-1)%uint32(len(s.pauses))]
+		if p > longest {
+			longest = p
+		}
+	}
+	return time.Duration(longest)
+}
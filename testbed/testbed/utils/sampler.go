@@ -0,0 +1,341 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Sample is a typed measurement taken by a probe.
+type Sample interface {
+	// Probe names the kind of measurement, e.g. "ledger".
+	Probe() string
+	// Columns and Values give the flat form of the sample for CSV output.
+	Columns() []string
+	Values() []string
+}
+
+// Probe takes a set of samples. It is called once per sampling interval.
+type Probe func() []Sample
+
+// LedgerSample is the bitswap ledger of a node with one of its peers.
+type LedgerSample struct {
+	Peer      string  `json:"peer"`
+	PeerID    string  `json:"peer_id"`
+	Sent      uint64  `json:"sent"`
+	Recv      uint64  `json:"recv"`
+	Value     float64 `json:"value"`
+	Exchanged uint64  `json:"exchanged"`
+}
+
+func (LedgerSample) Probe() string { return "ledger" }
+
+func (LedgerSample) Columns() []string {
+	return []string{"peer", "peer_id", "sent", "recv", "value", "exchanged"}
+}
+
+func (s LedgerSample) Values() []string {
+	return []string{s.Peer, s.PeerID, formatUint(s.Sent), formatUint(s.Recv), formatFloat(s.Value), formatUint(s.Exchanged)}
+}
+
+// BandwidthSample is the traffic of a node with a peer or over a protocol. An
+// empty Peer and Protocol stands for the totals of the node.
+type BandwidthSample struct {
+	Peer     string  `json:"peer,omitempty"`
+	Protocol string  `json:"protocol,omitempty"`
+	TotalIn  int64   `json:"total_in"`
+	TotalOut int64   `json:"total_out"`
+	RateIn   float64 `json:"rate_in"`
+	RateOut  float64 `json:"rate_out"`
+}
+
+func (BandwidthSample) Probe() string { return "bandwidth" }
+
+func (BandwidthSample) Columns() []string {
+	return []string{"peer", "protocol", "total_in", "total_out", "rate_in", "rate_out"}
+}
+
+func (s BandwidthSample) Values() []string {
+	return []string{s.Peer, s.Protocol, strconv.FormatInt(s.TotalIn, 10), strconv.FormatInt(s.TotalOut, 10),
+		formatFloat(s.RateIn), formatFloat(s.RateOut)}
+}
+
+// ExchangeSample holds the counters of the exchange of a node.
+type ExchangeSample struct {
+	MessagesReceived uint64 `json:"msgs_rcvd"`
+	DataSent         uint64 `json:"data_sent"`
+	DataReceived     uint64 `json:"data_rcvd"`
+	BlocksSent       uint64 `json:"blks_sent"`
+	BlocksReceived   uint64 `json:"blks_rcvd"`
+	DupBlksReceived  uint64 `json:"dup_blks_rcvd"`
+}
+
+func (ExchangeSample) Probe() string { return "exchange" }
+
+func (ExchangeSample) Columns() []string {
+	return []string{"msgs_rcvd", "data_sent", "data_rcvd", "blks_sent", "blks_rcvd", "dup_blks_rcvd"}
+}
+
+func (s ExchangeSample) Values() []string {
+	return []string{formatUint(s.MessagesReceived), formatUint(s.DataSent), formatUint(s.DataReceived),
+		formatUint(s.BlocksSent), formatUint(s.BlocksReceived), formatUint(s.DupBlksReceived)}
+}
+
+// ExchangeStatsSource is implemented by nodes that can report the counters of
+// their exchange while it runs.
+type ExchangeStatsSource interface {
+	ExchangeStats() (ExchangeSample, error)
+}
+
+// ExchangeProbe samples the exchange counters of a node. It returns nil if the
+// node doesn't report them.
+func ExchangeProbe(n Node) Probe {
+	src, ok := n.(ExchangeStatsSource)
+	if !ok {
+		return nil
+	}
+	return func() []Sample {
+		stats, err := src.ExchangeStats()
+		if err != nil {
+			return nil
+		}
+		return []Sample{stats}
+	}
+}
+
+// BandwidthProbe samples the traffic of a node, in total, per protocol and per
+// peer. peerName gives the name peers are reported under, or "" for peers
+// that should be left out.
+func BandwidthProbe(reporter metrics.Reporter, peerName func(peer.ID) string) Probe {
+	return func() []Sample {
+		totals := reporter.GetBandwidthTotals()
+		samples := []Sample{BandwidthSample{TotalIn: totals.TotalIn, TotalOut: totals.TotalOut, RateIn: totals.RateIn, RateOut: totals.RateOut}}
+		for proto, stats := range reporter.GetBandwidthByProtocol() {
+			samples = append(samples, BandwidthSample{Protocol: string(proto), TotalIn: stats.TotalIn, TotalOut: stats.TotalOut,
+				RateIn: stats.RateIn, RateOut: stats.RateOut})
+		}
+		for p, stats := range reporter.GetBandwidthByPeer() {
+			name := peerName(p)
+			if name == "" {
+				continue
+			}
+			samples = append(samples, BandwidthSample{Peer: name, TotalIn: stats.TotalIn, TotalOut: stats.TotalOut,
+				RateIn: stats.RateIn, RateOut: stats.RateOut})
+		}
+		return samples
+	}
+}
+
+// SampleSink writes the samples taken during a run.
+type SampleSink interface {
+	Write(at time.Time, run string, samples []Sample) error
+	Close() error
+}
+
+// NewSampleSink creates a sink writing to dir. jsonl writes every sample as a
+// line of <name>.jsonl, csv writes the samples of each probe to
+// <name>-<probe>.csv.
+func NewSampleSink(format string, dir string, name string) (SampleSink, error) {
+	switch format {
+	case "jsonl":
+		f, err := os.Create(filepath.Join(dir, name+".jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		return &jsonlSink{f: f, enc: json.NewEncoder(f)}, nil
+	case "csv":
+		return &csvSink{dir: dir, name: name, files: make(map[string]*csvFile)}, nil
+	default:
+		return nil, fmt.Errorf("Sample format %s not implemented", format)
+	}
+}
+
+type jsonlSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+type jsonlLine struct {
+	Time  time.Time `json:"time"`
+	Run   string    `json:"run"`
+	Probe string    `json:"probe"`
+	Data  Sample    `json:"data"`
+}
+
+func (s *jsonlSink) Write(at time.Time, run string, samples []Sample) error {
+	for _, sample := range samples {
+		if err := s.enc.Encode(jsonlLine{at, run, sample.Probe(), sample}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonlSink) Close() error {
+	return s.f.Close()
+}
+
+type csvFile struct {
+	f *os.File
+	w *csv.Writer
+}
+
+type csvSink struct {
+	dir   string
+	name  string
+	files map[string]*csvFile
+}
+
+func (s *csvSink) Write(at time.Time, run string, samples []Sample) error {
+	for _, sample := range samples {
+		cf, ok := s.files[sample.Probe()]
+		if !ok {
+			f, err := os.Create(filepath.Join(s.dir, fmt.Sprintf("%s-%s.csv", s.name, sample.Probe())))
+			if err != nil {
+				return err
+			}
+			cf = &csvFile{f, csv.NewWriter(f)}
+			if err := cf.w.Write(append([]string{"time", "run"}, sample.Columns()...)); err != nil {
+				return err
+			}
+			s.files[sample.Probe()] = cf
+		}
+		record := append([]string{strconv.FormatInt(at.UnixNano(), 10), run}, sample.Values()...)
+		if err := cf.w.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *csvSink) Close() error {
+	var firstErr error
+	for _, cf := range s.files {
+		cf.w.Flush()
+		if err := cf.w.Error(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := cf.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Sampler runs a set of probes at a fixed interval during a run and writes
+// their samples to a sink.
+type Sampler struct {
+	clock    Clock
+	interval time.Duration
+	sink     SampleSink
+
+	mu     sync.Mutex
+	run    string
+	probes []Probe
+	stop   chan struct{}
+	done   chan struct{}
+	err    error
+}
+
+// NewSampler creates a sampler that samples on the given clock. A nil sink
+// discards the samples, which is still useful for probes that feed other
+// consumers.
+func NewSampler(clock Clock, interval time.Duration, sink SampleSink) *Sampler {
+	return &Sampler{clock: clock, interval: interval, sink: sink}
+}
+
+// Start samples the given probes right away and then once per interval, until
+// Stop is called. Nil probes are skipped.
+func (s *Sampler) Start(run string, probes ...Probe) {
+	s.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run = run
+	s.probes = s.probes[:0]
+	for _, p := range probes {
+		if p != nil {
+			s.probes = append(s.probes, p)
+		}
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	s.sampleLocked()
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-s.clock.After(s.interval):
+				s.mu.Lock()
+				s.sampleLocked()
+				s.mu.Unlock()
+			}
+		}
+	}(s.stop, s.done)
+}
+
+// Stop takes a last sample and waits for the sampler to stop. It returns the
+// first error writing samples during the run.
+func (s *Sampler) Stop() error {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sampleLocked()
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Close stops the sampler and closes its sink.
+func (s *Sampler) Close() error {
+	err := s.Stop()
+	if s.sink == nil {
+		return err
+	}
+	if cerr := s.sink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Sampler) sampleLocked() {
+	now := s.clock.Now()
+	var samples []Sample
+	for _, p := range s.probes {
+		samples = append(samples, p()...)
+	}
+	if s.sink == nil || len(samples) == 0 {
+		return
+	}
+	if err := s.sink.Write(now, s.run, samples); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// SimLinks shape the traffic between bitswap nodes on virtual time. Like the
+// traffic shaping of the testground sidecar, every peer sends over an uplink
+// of its own bandwidth, shared by all the peers it sends to: sending a message
+// holds the sender until the uplink has sent it. The receiver gets it after
+// the latency of the link, the mean latency of its ends.
+type SimLinks struct {
+	clock *SimClock
+
+	mu     sync.Mutex
+	shapes map[peer.ID]simShape
+	// Virtual times at which the uplink of each peer is done sending the
+	// messages it took so far.
+	uplinks map[peer.ID]time.Time
+	// Messages in flight on each link, in the order they were sent.
+	inFlight map[[2]peer.ID][]simMessage
+}
+
+type simShape struct {
+	latency time.Duration
+	// In bytes per second, or unlimited if 0.
+	bandwidth float64
+}
+
+type simMessage struct {
+	msg bsmsg.BitSwapMessage
+	// Virtual time at which the message is due at the receiver.
+	due time.Time
+}
+
+func NewSimLinks(clock *SimClock) *SimLinks {
+	return &SimLinks{
+		clock:    clock,
+		shapes:   make(map[peer.ID]simShape),
+		uplinks:  make(map[peer.ID]time.Time),
+		inFlight: make(map[[2]peer.ID][]simMessage),
+	}
+}
+
+// SetShape sets the latency and the bandwidth, in bytes per second, of the
+// traffic of a peer.
+func (l *SimLinks) SetShape(p peer.ID, latency time.Duration, bandwidth float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shapes[p] = simShape{latency, bandwidth}
+}
+
+// Hooks returns the hooks of the bitswap node of peer self. Every message
+// sent has to be received, so they go after the hooks that drop or replace
+// messages. The exchange doesn't take part in the clock, see SimClock.
+func (l *SimLinks) Hooks(self peer.ID) MessageHooks {
+	return MessageHooks{
+		Outgoing: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+			l.send(l.clock, self, p, msg)
+			return msg
+		},
+		Incoming: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+			l.receive(l.clock, p, self)
+			l.clock.Touch()
+			return msg
+		},
+		// Messages that weren't sent never arrive, so they mustn't hold the
+		// place of the next ones.
+		SendFailed: func(p peer.ID, msg bsmsg.BitSwapMessage) {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			key := [2]peer.ID{self, p}
+			q := l.inFlight[key]
+			for i, m := range q {
+				if m.msg == msg {
+					l.inFlight[key] = append(q[:i:i], q[i+1:]...)
+					return
+				}
+			}
+		},
+	}
+}
+
+// send holds the sender of a message on clock until its uplink has sent it,
+// and puts the message in flight to the receiver.
+func (l *SimLinks) send(clock Clock, from, to peer.ID, msg bsmsg.BitSwapMessage) {
+	// Reserve the uplink of the sender for the message.
+	l.mu.Lock()
+	uplink := l.shapes[from]
+	latency := (uplink.latency + l.shapes[to].latency) / 2
+	var wait time.Duration
+	if uplink.bandwidth > 0 {
+		now := l.clock.Now()
+		free := l.uplinks[from]
+		if free.Before(now) {
+			free = now
+		}
+		free = free.Add(secondsToDuration(float64(msg.ToProtoV1().Size()) / uplink.bandwidth))
+		l.uplinks[from] = free
+		wait = free.Sub(now)
+	}
+	l.mu.Unlock()
+	if wait > 0 {
+		clock.Sleep(wait)
+	}
+
+	l.mu.Lock()
+	key := [2]peer.ID{from, to}
+	l.inFlight[key] = append(l.inFlight[key], simMessage{msg, l.clock.Now().Add(latency)})
+	l.mu.Unlock()
+}
+
+// receive holds the receiver of the next message in flight from a peer on
+// clock until the message is due.
+func (l *SimLinks) receive(clock Clock, from, to peer.ID) {
+	l.mu.Lock()
+	key := [2]peer.ID{from, to}
+	var due time.Time
+	if q := l.inFlight[key]; len(q) > 0 {
+		due, l.inFlight[key] = q[0].due, q[1:]
+	}
+	l.mu.Unlock()
+	if wait := due.Sub(l.clock.Now()); !due.IsZero() && wait > 0 {
+		clock.Sleep(wait)
+	}
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func newTestMessage(size int) bsmsg.BitSwapMessage {
+	msg := bsmsg.New(false)
+	msg.AddBlock(blocks.NewBlock(make([]byte, size)))
+	return msg
+}
+
+// simSend sends msg over the links as a participant of the clock, and
+// records when the uplink sent it and when it arrived under name.
+func simSend(c *SimClock, l *SimLinks, r *simRecorder, wg *sync.WaitGroup, name string, from, to peer.ID, msg bsmsg.BitSwapMessage) {
+	wg.Add(1)
+	c.Go(func(clock Clock) {
+		defer wg.Done()
+		l.send(clock, from, to, msg)
+		r.record(name+" sent", clock.Now())
+		wg.Add(1)
+		c.Go(func(clock Clock) {
+			defer wg.Done()
+			l.receive(clock, from, to)
+			r.record(name+" received", clock.Now())
+		})
+	})
+}
+
+func TestSimLinksUplink(t *testing.T) {
+	c := NewSimClock(testEpoch, 0)
+	defer c.Stop()
+	l := NewSimLinks(c)
+	l.SetShape("a", 10*time.Millisecond, 1e6)
+	l.SetShape("b", 30*time.Millisecond, 1e6)
+	l.SetShape("c", 50*time.Millisecond, 0)
+	msg := newTestMessage(100 * 1000)
+	tx := secondsToDuration(float64(msg.ToProtoV1().Size()) / 1e6)
+
+	// a sends to b and c at once, over the same uplink, while b sends to a
+	// over its own.
+	r := newSimRecorder()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.Go(func(Clock) {
+		defer wg.Done()
+		simSend(c, l, r, &wg, "a-b", "a", "b", msg)
+		simSend(c, l, r, &wg, "a-c", "a", "c", newTestMessage(100*1000))
+		simSend(c, l, r, &wg, "b-a", "b", "a", newTestMessage(100*1000))
+		// c has no bandwidth limit.
+		simSend(c, l, r, &wg, "c-b", "c", "b", newTestMessage(100*1000))
+	})
+	wait(t, &wg)
+
+	if r.at["b-a sent"] != tx || r.at["b-a received"] != tx+20*time.Millisecond {
+		t.Errorf("b-a sent at %s and received at %s, want %s and %s", r.at["b-a sent"], r.at["b-a received"], tx, tx+20*time.Millisecond)
+	}
+	if r.at["c-b sent"] != 0 || r.at["c-b received"] != 40*time.Millisecond {
+		t.Errorf("c-b sent at %s and received at %s, want 0s and 40ms", r.at["c-b sent"], r.at["c-b received"])
+	}
+	// Either message of a can go first, but the second waits for the first.
+	sent := []time.Duration{r.at["a-b sent"], r.at["a-c sent"]}
+	sort.Slice(sent, func(i, j int) bool { return sent[i] < sent[j] })
+	if sent[0] != tx || sent[1] != 2*tx {
+		t.Errorf("a sent its messages at %v, want %s and %s", sent, tx, 2*tx)
+	}
+	if d := r.at["a-b received"] - r.at["a-b sent"]; d != 20*time.Millisecond {
+		t.Errorf("a-b took %s to arrive, want 20ms", d)
+	}
+	if d := r.at["a-c received"] - r.at["a-c sent"]; d != 30*time.Millisecond {
+		t.Errorf("a-c took %s to arrive, want 30ms", d)
+	}
+}
+
+// simExchange has every peer send messages of random sizes to the others, one
+// at a time, while the scheduling of goroutines is shaken up in wall time.
+func simExchange(t *testing.T) map[string]time.Duration {
+	c := NewSimClock(testEpoch, 0)
+	defer c.Stop()
+	l := NewSimLinks(c)
+	peers := []peer.ID{"a", "b", "c", "d"}
+	for i, p := range peers {
+		l.SetShape(p, time.Duration(i+1)*10*time.Millisecond, float64(i+1)*1e5)
+	}
+	sizes := rand.New(rand.NewSource(1))
+
+	r := newSimRecorder()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	c.Go(func(Clock) {
+		defer wg.Done()
+		for _, from := range peers {
+			from := from
+			msgs := make(map[peer.ID][]bsmsg.BitSwapMessage)
+			pauses := make(map[peer.ID][]time.Duration)
+			for _, to := range peers {
+				for i := 0; to != from && i < 5; i++ {
+					msgs[to] = append(msgs[to], newTestMessage(1000+sizes.Intn(100*1000)))
+					pauses[to] = append(pauses[to], time.Duration(sizes.Intn(1000))*time.Microsecond)
+				}
+			}
+			wg.Add(1)
+			c.Go(func(clock Clock) {
+				defer wg.Done()
+				for i := 0; i < 5; i++ {
+					for _, to := range peers {
+						if to == from {
+							continue
+						}
+						time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+						simSend(c, l, r, &wg, fmt.Sprintf("%s-%s %d", from, to, i), from, to, msgs[to][i])
+						clock.Sleep(pauses[to][i])
+					}
+				}
+			})
+		}
+	})
+	wait(t, &wg)
+	return r.at
+}
+
+func TestSimLinksRepeat(t *testing.T) {
+	first, second := simExchange(t), simExchange(t)
+	if len(first) != 4*3*5*2 {
+		t.Fatalf("Recorded %d events, want %d", len(first), 4*3*5*2)
+	}
+	for name, at := range first {
+		if second[name] != at {
+			t.Errorf("%s at %s in the first run and %s in the second", name, at, second[name])
+		}
+	}
+}
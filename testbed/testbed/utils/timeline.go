@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// BlockArrival is a block received while fetching a file.
+type BlockArrival struct {
+	Cid  cid.Cid
+	Peer peer.ID
+	Size int
+	// Time since the fetch started.
+	At time.Duration
+}
+
+// Timeline records when each block of a fetch arrives and which peer sent it.
+// Only one fetch is followed at a time, from Start to Stop.
+type Timeline struct {
+	mu        sync.Mutex
+	clock     Clock
+	root      cid.Cid
+	start     time.Time
+	recording bool
+	arrivals  []BlockArrival
+	seen      map[cid.Cid]bool
+}
+
+func NewTimeline() *Timeline {
+	return &Timeline{clock: RealClock, seen: make(map[cid.Cid]bool)}
+}
+
+// SetClock sets the clock arrivals are timed with, before the first Start.
+func (tl *Timeline) SetClock(clock Clock) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.clock = clock
+}
+
+// Start drops the arrivals of the previous fetch and starts following the
+// fetch of root.
+func (tl *Timeline) Start(root cid.Cid) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.root = root
+	tl.start = tl.clock.Now()
+	tl.recording = true
+	tl.arrivals = nil
+	tl.seen = make(map[cid.Cid]bool)
+}
+
+// Stop stops recording arrivals. They are kept until the next Start.
+func (tl *Timeline) Stop() {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.recording = false
+}
+
+// Arrivals returns the blocks of the last fetch in the order they arrived.
+func (tl *Timeline) Arrivals() []BlockArrival {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return append([]BlockArrival(nil), tl.arrivals...)
+}
+
+// arrive records the first arrival of a block. Duplicates are left out.
+func (tl *Timeline) arrive(c cid.Cid, p peer.ID, size int) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	if !tl.recording || tl.seen[c] {
+		return
+	}
+	tl.seen[c] = true
+	tl.arrivals = append(tl.arrivals, BlockArrival{c, p, size, tl.clock.Since(tl.start)})
+}
+
+// BitswapHooks returns the hooks that record the blocks a bitswap node
+// receives. Blocks that don't match their CID are left out.
+func (tl *Timeline) BitswapHooks() MessageHooks {
+	return MessageHooks{Incoming: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+		for _, b := range msg.Blocks() {
+			if valid(b) {
+				tl.arrive(b.Cid(), p, len(b.RawData()))
+			}
+		}
+		return msg
+	}}
+}
+
+// Completion returns the time by which the given fraction (between 0 and 1)
+// of the bytes of the last fetch had arrived, and false if nothing arrived.
+func (tl *Timeline) Completion(fraction float64) (time.Duration, bool) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	var total int
+	for _, a := range tl.arrivals {
+		total += a.Size
+	}
+	if total == 0 {
+		return 0, false
+	}
+	var sum int
+	for _, a := range tl.arrivals {
+		sum += a.Size
+		if float64(sum) >= fraction*float64(total) {
+			return a.At, true
+		}
+	}
+	return tl.arrivals[len(tl.arrivals)-1].At, true
+}
+
+// EmitMetrics records the time to the first block, to the root block and to
+// half and 90% of the bytes of the last fetch. Metrics for events that never
+// happened are left out.
+func (tl *Timeline) EmitMetrics(recorder MetricsRecorder) {
+	tl.mu.Lock()
+	if len(tl.arrivals) > 0 {
+		recorder.Record("time_to_first_block", float64(tl.arrivals[0].At))
+	}
+	for _, a := range tl.arrivals {
+		if a.Cid.Equals(tl.root) {
+			recorder.Record("time_to_root", float64(a.At))
+			break
+		}
+	}
+	tl.mu.Unlock()
+
+	if at, ok := tl.Completion(0.5); ok {
+		recorder.Record("time_to_p50", float64(at))
+	}
+	if at, ok := tl.Completion(0.9); ok {
+		recorder.Record("time_to_p90", float64(at))
+	}
+}
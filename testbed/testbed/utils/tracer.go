@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	pb "github.com/ipfs/go-bitswap/message/pb"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TraceEvent is a bitswap message sent or received by a node, as written to
+// its trace.
+type TraceEvent struct {
+	Time time.Time `json:"time"`
+	// Node and peer are named as in the experiment, e.g. "leech:1", when
+	// known.
+	Node      string       `json:"node"`
+	Peer      string       `json:"peer"`
+	PeerID    string       `json:"peer_id"`
+	Direction string       `json:"direction"` // "sent" or "received"
+	Size      int          `json:"size"`
+	Full      bool         `json:"full,omitempty"`
+	Wants     []TraceWant  `json:"wants,omitempty"`
+	Cancels   []string     `json:"cancels,omitempty"`
+	Blocks    []TraceBlock `json:"blocks,omitempty"`
+	Haves     []string     `json:"haves,omitempty"`
+	DontHaves []string     `json:"dont_haves,omitempty"`
+	Pending   int32        `json:"pending_bytes,omitempty"`
+}
+
+// TraceWant is a wantlist entry of a traced message.
+type TraceWant struct {
+	Cid          string `json:"cid"`
+	Type         string `json:"type"` // "block" or "have"
+	Priority     int32  `json:"priority"`
+	SendDontHave bool   `json:"send_dont_have,omitempty"`
+}
+
+// TraceBlock is a block carried by a traced message.
+type TraceBlock struct {
+	Cid  string `json:"cid"`
+	Size int    `json:"size"`
+}
+
+// MessageTracer writes every bitswap message a node sends and receives to a
+// JSON lines file, one TraceEvent per line.
+type MessageTracer struct {
+	node     string
+	peerName func(peer.ID) string
+	clock    Clock
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+// NewMessageTracer creates a tracer writing to path for the node with the
+// given name, timing messages with clock. peerName gives the name peers are
+// traced under, or "" if they have none.
+func NewMessageTracer(path string, node string, peerName func(peer.ID) string, clock Clock) (*MessageTracer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &MessageTracer{node: node, peerName: peerName, clock: clock, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Hooks returns the hooks that trace the messages of a bitswap node. They
+// should go after any hook that rewrites messages, so the trace shows what
+// goes on the wire.
+func (t *MessageTracer) Hooks() MessageHooks {
+	return MessageHooks{
+		Outgoing: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+			t.trace("sent", p, msg)
+			return msg
+		},
+		Incoming: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+			t.trace("received", p, msg)
+			return msg
+		},
+	}
+}
+
+func (t *MessageTracer) trace(direction string, p peer.ID, msg bsmsg.BitSwapMessage) {
+	ev := TraceEvent{
+		Time:      t.clock.Now(),
+		Node:      t.node,
+		Peer:      t.peerName(p),
+		PeerID:    p.String(),
+		Direction: direction,
+		Size:      msg.ToProtoV1().Size(),
+		Full:      msg.Full(),
+		Pending:   msg.PendingBytes(),
+	}
+	for _, e := range msg.Wantlist() {
+		if e.Cancel {
+			ev.Cancels = append(ev.Cancels, e.Cid.String())
+			continue
+		}
+		wantType := "block"
+		if e.WantType == pb.Message_Wantlist_Have {
+			wantType = "have"
+		}
+		ev.Wants = append(ev.Wants, TraceWant{e.Cid.String(), wantType, e.Priority, e.SendDontHave})
+	}
+	for _, b := range msg.Blocks() {
+		ev.Blocks = append(ev.Blocks, TraceBlock{b.Cid().String(), len(b.RawData())})
+	}
+	ev.Haves = cidStrings(msg.Haves())
+	ev.DontHaves = cidStrings(msg.DontHaves())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = t.enc.Encode(ev)
+	}
+}
+
+// Close flushes the trace and closes its file. It returns the first error
+// writing the trace.
+func (t *MessageTracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.err
+	if ferr := t.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func cidStrings(cids []cid.Cid) []string {
+	var out []string
+	for _, c := range cids {
+		out = append(out, c.String())
+	}
+	return out
+}
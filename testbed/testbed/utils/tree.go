@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+)
+
+// maxTreeDirs bounds the directories of a generated tree, so a typo in its
+// depth or fanout doesn't exhaust the memory of the node.
+const maxTreeDirs = 1 << 20
+
+// TreeFile is a directory tree generated from a seed. The tree is depth
+// levels deep with fanout subdirectories in every directory above the last
+// level, and its files are spread over all the directories in turn, in
+// breadth-first order. File sizes are drawn from a size distribution. A
+// single directory with many files (depth 0) tests wide directories and
+// sharding, deep trees with small files test many small blocks.
+type TreeFile struct {
+	depth  int
+	fanout int
+	sizes  []int64
+	// Contents of every file are generated from a seed drawn from the seed
+	// of the tree, so they don't share blocks with other trees or files.
+	seeds []int64
+	dirs  int
+}
+
+// NewTreeFile returns a directory tree of the given shape with fileCount
+// files, whose sizes are drawn from sizes. The tree and the contents of its
+// files are generated from seed.
+func NewTreeFile(depth, fanout, fileCount int, sizes SizeDistribution, seed int64) (*TreeFile, error) {
+	if depth < 0 || fanout < 0 || fileCount < 0 {
+		return nil, fmt.Errorf("Trees need a non-negative depth, fanout and file count")
+	}
+	dirs, width := 1, 1
+	for level := 0; level < depth && fanout > 0; level++ {
+		width *= fanout
+		dirs += width
+		if dirs > maxTreeDirs {
+			return nil, fmt.Errorf("Tree of depth %d and fanout %d has more than %d directories", depth, fanout, maxTreeDirs)
+		}
+	}
+	r := rand.New(rand.NewSource(seed))
+	f := &TreeFile{depth, fanout, make([]int64, fileCount), make([]int64, fileCount), dirs}
+	for i := range f.sizes {
+		f.sizes[i] = sizes.sample(r)
+		f.seeds[i] = r.Int63()
+	}
+	return f, nil
+}
+
+// GenerateFile generates the tree. File contents are generated as they are
+// read, so large trees don't need to fit in memory.
+func (f *TreeFile) GenerateFile() (files.Node, error) {
+	entries := make([]map[string]files.Node, f.dirs)
+	for d := range entries {
+		entries[d] = make(map[string]files.Node)
+	}
+	for i, size := range f.sizes {
+		d := i % f.dirs
+		entries[d][fmt.Sprintf("file-%d", i/f.dirs)] = files.NewReaderFile(seededReader(size, f.seeds[i]))
+	}
+	// The subdirectories of directory d are d*fanout+1 to d*fanout+fanout,
+	// so building them from the last one has every child ready before its
+	// parent.
+	nodes := make([]files.Node, f.dirs)
+	for d := f.dirs - 1; d >= 0; d-- {
+		for c := 0; c < f.fanout; c++ {
+			if child := d*f.fanout + 1 + c; child < f.dirs {
+				entries[d][fmt.Sprintf("dir-%d", c)] = nodes[child]
+			}
+		}
+		nodes[d] = files.NewMapDirectory(entries[d])
+	}
+	return nodes[0], nil
+}
+
+// Size returns the size of all the files in the tree.
+func (f *TreeFile) Size() int64 {
+	var size int64
+	for _, s := range f.sizes {
+		size += s
+	}
+	return size
+}
+
+func (f *TreeFile) String() string {
+	return fmt.Sprintf("tree of depth %d, fanout %d and %d files (%d bytes)", f.depth, f.fanout, len(f.sizes), f.Size())
+}
+
+// seededReader reads size bytes generated from seed, the same bytes
+// SeededRandReader returns, without holding them in memory.
+func seededReader(size int64, seed int64) io.Reader {
+	return io.LimitReader(rand.New(rand.NewSource(seed)), size)
+}
+
+// SizeDistribution draws the sizes of the files of generated trees.
+type SizeDistribution interface {
+	sample(r *rand.Rand) int64
+}
+
+type fixedSize int64
+
+func (s fixedSize) sample(*rand.Rand) int64 { return int64(s) }
+
+type uniformSize struct{ min, max int64 }
+
+func (s uniformSize) sample(r *rand.Rand) int64 {
+	return s.min + r.Int63n(s.max-s.min+1)
+}
+
+// logNormalSize has the long tail of file sizes in real datasets: most files
+// are close to the median and a few are much larger.
+type logNormalSize struct {
+	median float64
+	sigma  float64
+}
+
+func (s logNormalSize) sample(r *rand.Rand) int64 {
+	return int64(math.Round(s.median * math.Exp(s.sigma*r.NormFloat64())))
+}
+
+// ParseSizeDistribution parses a size distribution, in bytes:
+// - fixed:<size>: every file has the same size.
+// - uniform:<min>:<max>: sizes are uniform between min and max, inclusive.
+// - lognormal:<median>:<sigma>: sizes are log-normal around median, with the
+// standard deviation sigma of their logarithm.
+// A plain size is the same as fixed:<size>.
+func ParseSizeDistribution(s string) (SizeDistribution, error) {
+	parts := strings.Split(s, ":")
+	invalid := fmt.Errorf("Invalid size distribution '%s'", s)
+	switch {
+	case len(parts) == 1 || (parts[0] == "fixed" && len(parts) == 2):
+		size, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+		if err != nil || size < 0 {
+			return nil, invalid
+		}
+		return fixedSize(size), nil
+	case parts[0] == "uniform" && len(parts) == 3:
+		min, err1 := strconv.ParseInt(parts[1], 10, 64)
+		max, err2 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 != nil || err2 != nil || min < 0 || max < min {
+			return nil, invalid
+		}
+		return uniformSize{min, max}, nil
+	case parts[0] == "lognormal" && len(parts) == 3:
+		median, err1 := strconv.ParseFloat(parts[1], 64)
+		sigma, err2 := strconv.ParseFloat(parts[2], 64)
+		if err1 != nil || err2 != nil || median <= 0 || sigma < 0 {
+			return nil, invalid
+		}
+		return logNormalSize{median, sigma}, nil
+	default:
+		return nil, invalid
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func newTestTree(t *testing.T, depth, fanout, fileCount int, sizes string, seed int64) (*TreeFile, map[string][]byte) {
+	t.Helper()
+	dist, err := ParseSizeDistribution(sizes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewTreeFile(depth, fanout, fileCount, dist, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := tree.GenerateFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := readTree(nd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree, contents
+}
+
+func TestTreeShape(t *testing.T) {
+	tree, contents := newTestTree(t, 2, 3, 30, "fixed:100", 1)
+	if len(contents) != 30 {
+		t.Fatalf("Tree has %d files, want 30", len(contents))
+	}
+	if tree.Size() != 3000 {
+		t.Errorf("Tree size is %d, want 3000", tree.Size())
+	}
+
+	// 1 + 3 + 9 directories, with the 30 files spread over them in turn.
+	perDir := make(map[string]int)
+	for path, data := range contents {
+		if len(data) != 100 {
+			t.Errorf("%s has %d bytes, want 100", path, len(data))
+		}
+		dir := ""
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			dir = path[:i]
+		}
+		if depth := strings.Count(dir, "/") + 1; dir != "" && depth > 2 {
+			t.Errorf("%s is %d levels deep, want at most 2", path, depth)
+		}
+		perDir[dir]++
+	}
+	if len(perDir) != 13 {
+		t.Errorf("Files are in %d directories, want 13", len(perDir))
+	}
+	for dir, n := range perDir {
+		if n < 2 || n > 3 {
+			t.Errorf("Directory %q has %d files, want 2 or 3", dir, n)
+		}
+	}
+
+	// A depth of 0 puts every file in a single directory.
+	_, flat := newTestTree(t, 0, 3, 5, "fixed:10", 1)
+	for path := range flat {
+		if strings.Contains(path, "/") {
+			t.Errorf("%s isn't at the root of a tree of depth 0", path)
+		}
+	}
+}
+
+func TestTreeDeterminism(t *testing.T) {
+	a, contentsA := newTestTree(t, 2, 2, 20, "lognormal:2000:1", 7)
+	b, contentsB := newTestTree(t, 2, 2, 20, "lognormal:2000:1", 7)
+	if a.Size() != b.Size() {
+		t.Fatalf("Trees of the same seed have sizes %d and %d", a.Size(), b.Size())
+	}
+	if len(contentsA) != len(contentsB) {
+		t.Fatalf("Trees of the same seed have %d and %d files", len(contentsA), len(contentsB))
+	}
+	for path, data := range contentsA {
+		if !bytes.Equal(data, contentsB[path]) {
+			t.Errorf("%s differs between trees of the same seed", path)
+		}
+	}
+}
+
+// TestTreeContents checks that no two files of neighbouring trees, or of a
+// tree and random files, start with the same bytes, so they don't share
+// blocks.
+func TestTreeContents(t *testing.T) {
+	prefixes := make(map[string]string)
+	add := func(name string, data []byte) {
+		prefix := string(data[:64])
+		if other, ok := prefixes[prefix]; ok {
+			t.Errorf("%s starts like %s", name, other)
+		}
+		prefixes[prefix] = name
+	}
+	for seed := int64(1); seed <= 3; seed++ {
+		_, contents := newTestTree(t, 1, 2, 6, "fixed:1024", seed)
+		for path, data := range contents {
+			add(fmt.Sprintf("%s of tree %d", path, seed), data)
+		}
+	}
+	for seed := int64(0); seed <= 8; seed++ {
+		// The contents of NewRandFile(1024, seed).
+		data, err := ioutil.ReadAll(SeededRandReader(1024, seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		add(fmt.Sprintf("random file %d", seed), data)
+	}
+}
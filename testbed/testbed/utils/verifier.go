@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/go-bitswap/message"
+	pb "github.com/ipfs/go-bitswap/message/pb"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	gsmsg "github.com/ipfs/go-graphsync/message"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// BlockVerifier accounts for the blocks a node receives but can't use, either
+// because their content doesn't match their CID or because the node never
+// asked for them, and for the time it loses waiting on peers that don't
+// deliver.
+type BlockVerifier struct {
+	mu sync.Mutex
+	// Blocks asked for, and the first peer asked for each of them (bitswap).
+	requested map[cid.Cid]bool
+	wants     map[cid.Cid]pendingWant
+	// Blocks received, waiting for the exchange to use them (graphsync).
+	unused   map[cid.Cid]uint64
+	received map[cid.Cid]bool
+
+	rejected uint64
+	wasted   uint64
+	lost     time.Duration
+}
+
+type pendingWant struct {
+	p  peer.ID
+	at time.Time
+}
+
+func NewBlockVerifier() *BlockVerifier {
+	v := &BlockVerifier{}
+	v.reset()
+	return v
+}
+
+func (v *BlockVerifier) reset() {
+	v.requested = make(map[cid.Cid]bool)
+	v.wants = make(map[cid.Cid]pendingWant)
+	v.unused = make(map[cid.Cid]uint64)
+	v.received = make(map[cid.Cid]bool)
+	v.rejected, v.wasted, v.lost = 0, 0, 0
+}
+
+// valid checks the content of a block against its CID. Bitswap and graphsync
+// derive the CIDs of the blocks they receive from their content, so corrupt
+// blocks don't fail this check once decoded: they are rejected as blocks
+// nobody asked for instead.
+func valid(b blocks.Block) bool {
+	c, err := b.Cid().Prefix().Sum(b.RawData())
+	return err == nil && c.Equals(b.Cid())
+}
+
+func (v *BlockVerifier) reject(b blocks.Block) {
+	v.rejected++
+	v.wasted += uint64(len(b.RawData()))
+}
+
+// BitswapHooks returns the hooks that follow the wants a bitswap node sends
+// and check the blocks it receives against them.
+func (v *BlockVerifier) BitswapHooks() MessageHooks {
+	return MessageHooks{
+		Outgoing: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			now := time.Now()
+			for _, e := range msg.Wantlist() {
+				if e.Cancel {
+					continue
+				}
+				v.requested[e.Cid] = true
+				delete(v.received, e.Cid)
+				if _, ok := v.wants[e.Cid]; !ok && e.WantType == pb.Message_Wantlist_Block {
+					v.wants[e.Cid] = pendingWant{p, now}
+				}
+			}
+			return msg
+		},
+		Incoming: func(_ context.Context, p peer.ID, msg bsmsg.BitSwapMessage) bsmsg.BitSwapMessage {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			now := time.Now()
+			for _, b := range msg.Blocks() {
+				c := b.Cid()
+				switch {
+				case !valid(b), !v.requested[c]:
+					v.reject(b)
+				case v.received[c]:
+					// Duplicates are accounted for by bitswap itself.
+				default:
+					v.received[c] = true
+					// Time spent waiting on a peer that never sent the block.
+					if w, ok := v.wants[c]; ok && w.p != p {
+						v.lost += now.Sub(w.at)
+					}
+					delete(v.wants, c)
+				}
+			}
+			return msg
+		},
+	}
+}
+
+// GraphsyncHooks returns the hooks that check the blocks a graphsync node
+// receives. Blocks are only accepted once graphsync uses them, see used.
+func (v *BlockVerifier) GraphsyncHooks() GraphsyncMessageHooks {
+	return GraphsyncMessageHooks{
+		Incoming: func(_ context.Context, _ peer.ID, msg gsmsg.GraphSyncMessage) gsmsg.GraphSyncMessage {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			for _, b := range msg.Blocks() {
+				if !valid(b) {
+					v.reject(b)
+					continue
+				}
+				v.unused[b.Cid()] += uint64(len(b.RawData()))
+			}
+			return msg
+		},
+	}
+}
+
+// used marks a received block as used by the exchange.
+func (v *BlockVerifier) used(c cid.Cid) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.unused, c)
+}
+
+// lose adds time spent on a request that didn't deliver.
+func (v *BlockVerifier) lose(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.lost += d
+}
+
+// EmitMetrics records the blocks rejected since the last call, the bytes they
+// took and the time lost, and starts over. It is meant to be called once per
+// run.
+func (v *BlockVerifier) EmitMetrics(recorder MetricsRecorder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// Blocks graphsync never used are as good as rejected.
+	for _, size := range v.unused {
+		v.rejected++
+		v.wasted += size
+	}
+	recorder.Record("rejected_blks", float64(v.rejected))
+	recorder.Record("wasted_data", float64(v.wasted))
+	recorder.Record("time_lost", float64(v.lost))
+	v.reset()
+}
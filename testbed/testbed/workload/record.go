@@ -0,0 +1,93 @@
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RecordsPrefix starts the names of the files leeches record their fetches
+// to, workload-<type>-<index>.jsonl in their outputs.
+const RecordsPrefix = "workload-"
+
+// Record is a fetch recorded by a leech, a line of its records file. Each
+// record carries the topology of its run so a trace can be assembled from the
+// records alone.
+type Record struct {
+	Run      string   `json:"run"`
+	Topology Topology `json:"topology"`
+	Leech    int      `json:"leech"`
+	Item     Item     `json:"item"`
+	OffsetMS float64  `json:"offset_ms"`
+}
+
+// ReadRecords reads the records of every records file under dir, such as the
+// outputs collected from a testground run.
+func ReadRecords(dir string) ([]Record, error) {
+	var records []Record
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, RecordsPrefix) || filepath.Ext(name) != ".jsonl" {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dec := json.NewDecoder(f)
+		for line := 1; ; line++ {
+			var r Record
+			if err := dec.Decode(&r); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("Error reading record %d of %s: %w", line, path, err)
+			}
+			records = append(records, r)
+		}
+	})
+	return records, err
+}
+
+// Runs returns the runs records were made in, in the order they first
+// appear.
+func Runs(records []Record) []string {
+	var runs []string
+	seen := make(map[string]bool)
+	for _, r := range records {
+		if !seen[r.Run] {
+			seen[r.Run] = true
+			runs = append(runs, r.Run)
+		}
+	}
+	return runs
+}
+
+// Assemble makes a trace of the records of a run.
+func Assemble(records []Record, run string) (*Trace, error) {
+	t := &Trace{}
+	found := false
+	for _, r := range records {
+		if r.Run != run {
+			continue
+		}
+		if !found {
+			t.Topology = r.Topology
+			found = true
+		} else if r.Topology != t.Topology {
+			return nil, fmt.Errorf("Records of run %s have different topologies, %+v and %+v", run, t.Topology, r.Topology)
+		}
+		t.Requests = append(t.Requests, Request{r.Leech, t.AddItem(r.Item), r.OffsetMS})
+	}
+	if !found {
+		return nil, fmt.Errorf("No records of run %s", run)
+	}
+	t.Sort()
+	return t, t.Validate()
+}
@@ -0,0 +1,133 @@
+// Package workload defines traces of fetch workloads: which items each leech
+// requested, when it requested them, and the topology it did it in. Traces
+// are recorded from transfer runs or with the probe, and replayed by the
+// replay test case so exchanges can be compared on the same workload.
+package workload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+)
+
+// Trace is a fetch workload.
+type Trace struct {
+	Topology Topology  `json:"topology"`
+	Items    []Item    `json:"items"`
+	Requests []Request `json:"requests"`
+}
+
+// Topology is the network the workload ran on. Bandwidth is in Mib/s, like
+// the bandwidth_mb test parameter.
+type Topology struct {
+	Seeds       int   `json:"seeds"`
+	Leeches     int   `json:"leeches"`
+	Passives    int   `json:"passives"`
+	LatencyMS   int64 `json:"latency_ms"`
+	BandwidthMB int   `json:"bandwidth_mb"`
+	JitterPct   int   `json:"jitter_pct"`
+}
+
+// Instances is the number of instances needed to replay the workload.
+func (t Topology) Instances() int {
+	return t.Seeds + t.Leeches + t.Passives
+}
+
+// Item is a file requested in the workload. Replays regenerate random files
+// from their size and seed, and read other files from their path, relative
+// to the data_dir of the replay unless absolute. The CID is the one the file
+// had when it was recorded; other node types may chunk it differently.
+type Item struct {
+	CID  string `json:"cid"`
+	Size int64  `json:"size"`
+	Seed *int64 `json:"seed,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// Request is a fetch of an item by a leech, by type index.
+type Request struct {
+	Leech int `json:"leech"`
+	Item  int `json:"item"`
+	// Milliseconds from the start of the workload to the request.
+	OffsetMS float64 `json:"offset_ms"`
+}
+
+// Offset returns the time from the start of the workload to the request.
+func (r Request) Offset() time.Duration {
+	return time.Duration(r.OffsetMS * float64(time.Millisecond))
+}
+
+// AddItem adds an item to the trace, unless an item with the same CID is
+// already in it, and returns its index.
+func (t *Trace) AddItem(item Item) int {
+	for i, it := range t.Items {
+		if it.CID == item.CID {
+			return i
+		}
+	}
+	t.Items = append(t.Items, item)
+	return len(t.Items) - 1
+}
+
+// Sort sorts the requests by offset, then by leech.
+func (t *Trace) Sort() {
+	sort.SliceStable(t.Requests, func(i, j int) bool {
+		ri, rj := t.Requests[i], t.Requests[j]
+		if ri.OffsetMS != rj.OffsetMS {
+			return ri.OffsetMS < rj.OffsetMS
+		}
+		return ri.Leech < rj.Leech
+	})
+}
+
+// Validate checks that the trace can be replayed.
+func (t *Trace) Validate() error {
+	if t.Topology.Seeds < 1 || t.Topology.Leeches < 1 {
+		return fmt.Errorf("Workload needs at least a seed and a leech, got %d seeds and %d leeches",
+			t.Topology.Seeds, t.Topology.Leeches)
+	}
+	for i, it := range t.Items {
+		if it.Seed == nil && it.Path == "" {
+			return fmt.Errorf("Item %d (%s) has neither a seed nor a path to generate it from", i, it.CID)
+		}
+	}
+	for i, r := range t.Requests {
+		if r.Leech < 0 || r.Leech >= t.Topology.Leeches {
+			return fmt.Errorf("Request %d is from leech %d of %d", i, r.Leech, t.Topology.Leeches)
+		}
+		if r.Item < 0 || r.Item >= len(t.Items) {
+			return fmt.Errorf("Request %d is for item %d of %d", i, r.Item, len(t.Items))
+		}
+		if r.OffsetMS < 0 {
+			return fmt.Errorf("Request %d has a negative offset", i)
+		}
+	}
+	return nil
+}
+
+// ReadFile reads and validates the trace at path.
+func ReadFile(path string) (*Trace, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t Trace
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("Error reading workload %s: %w", path, err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid workload %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// WriteFile writes the trace to path.
+func (t *Trace) WriteFile(path string) error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -1,11 +1,27 @@
 var http = require('http');
+var fs = require('fs');
+var path = require('path');
+
+// Directory with bitswap-trace-*.jsonl files from testbed runs, served under
+// /traces/ and shown by the trace viewer under /viewer (optional).
+var tracesDir = process.argv[2];
 
 console.log("Proxy server running in port 3000...")
+if (tracesDir) {
+    console.log("Serving testbed traces from " + tracesDir + " under /traces/, view them at http://localhost:3000/viewer")
+}
 http.createServer(onRequest).listen(3000);
 
 function onRequest(client_req, client_res) {
     console.log('serve: ' + client_req.url);
 
+    if (tracesDir && client_req.url.startsWith('/traces/')) {
+        return serveTrace(client_req, client_res);
+    }
+    if (tracesDir && client_req.url === '/viewer') {
+        return serveViewer(client_req, client_res);
+    }
+
     var options = {
         hostname: 'localhost',
         port: 16686,
@@ -27,4 +43,56 @@ function onRequest(client_req, client_res) {
     client_req.pipe(proxy, {
         end: true
     });
-}
\ No newline at end of file
+}
+
+// Prefix of the trace files the testbed writes.
+var tracePrefix = 'bitswap-trace-';
+
+// serveTrace returns a trace file, or the list of trace files for /traces/.
+function serveTrace(client_req, client_res) {
+    var headers = {
+        "Access-Control-Allow-Origin": "*",
+        "Access-Control-Allow-Headers": "Origin, X-Requested-With, Content-Type, Accept",
+        "Access-Control-Allow-Methods": "OPTIONS, GET"
+    };
+    var name = decodeURIComponent(client_req.url.slice('/traces/'.length));
+    if (name === '') {
+        fs.readdir(tracesDir, function (err, files) {
+            if (err) {
+                client_res.writeHead(500, headers);
+                return client_res.end(err.message);
+            }
+            headers["Content-Type"] = "application/json";
+            client_res.writeHead(200, headers);
+            client_res.end(JSON.stringify(files.filter(function (f) { return f.startsWith(tracePrefix) && f.endsWith('.jsonl'); })));
+        });
+        return;
+    }
+    // Only serve trace files right under the traces directory.
+    if (name !== path.basename(name) || !name.startsWith(tracePrefix)) {
+        client_res.writeHead(404, headers);
+        return client_res.end();
+    }
+    var stream = fs.createReadStream(path.join(tracesDir, name));
+    stream.on('error', function () {
+        client_res.writeHead(404, headers);
+        client_res.end();
+    });
+    stream.on('open', function () {
+        headers["Content-Type"] = "application/x-ndjson";
+        client_res.writeHead(200, headers);
+        stream.pipe(client_res);
+    });
+}
+
+// serveViewer returns the page that shows the testbed traces.
+function serveViewer(client_req, client_res) {
+    fs.readFile(path.join(__dirname, 'traces.html'), function (err, data) {
+        if (err) {
+            client_res.writeHead(500);
+            return client_res.end(err.message);
+        }
+        client_res.writeHead(200, {"Content-Type": "text/html; charset=utf-8"});
+        client_res.end(data);
+    });
+}